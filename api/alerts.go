@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"openrouter-bot/config"
+	"openrouter-bot/lang"
+	"openrouter-bot/user"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// BudgetAlerts notifies users when their spending crosses one of the
+// BUDGET_THRESHOLDS, and admins when a user or the spending of all users
+// (against GLOBAL_BUDGET) does.
+type BudgetAlerts struct {
+	bot   *tgbotapi.BotAPI
+	conf  *config.Config
+	users *user.Manager
+	file  string
+	mu    sync.Mutex
+	// global is the highest threshold of the global budget notified
+	global user.BudgetAlert
+	// spent is the spending of all users in the budget period starting on
+	// spentSince, kept up to date with the charges
+	spent      float64
+	spentSince string
+}
+
+// NewBudgetAlerts sets up the notifications, the global alert state is kept in logsDir.
+func NewBudgetAlerts(bot *tgbotapi.BotAPI, conf *config.Config, users *user.Manager, logsDir string) *BudgetAlerts {
+	a := &BudgetAlerts{
+		bot:   bot,
+		conf:  conf,
+		users: users,
+		file:  filepath.Join(logsDir, "budget_alerts.json"),
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading budget alerts: %v", err)
+		}
+		return a
+	}
+	if err := json.Unmarshal(data, &a.global); err != nil {
+		log.Printf("Error unmarshalling budget alerts: %v", err)
+	}
+	return a
+}
+
+// Check notifies about the thresholds crossed by the last charge of the user,
+// cost charged on day (YYYY-MM-DD).
+func (a *BudgetAlerts) Check(ut *user.UsageTracker, day string, cost float64) {
+	if status, ok := ut.CrossedThreshold(a.conf); ok {
+		userLang := ut.Lang(a.conf)
+		key := "alerts.threshold"
+		if status.Threshold >= 100 {
+			key = "alerts.exhausted"
+		}
+		text := fmt.Sprintf(lang.Translate(key, userLang), status.Threshold, status.Spent, status.Budget,
+			max(status.Budget-status.Spent, 0)) + " " + resetText(status, userLang)
+		a.send(ut.UserID, text)
+
+		name := html.EscapeString(ut.UserID)
+		if ut.UserName != "" {
+			name = fmt.Sprintf("@%s (%s)", html.EscapeString(ut.UserName), name)
+		}
+		a.notifyAdmins(ut, "alerts.adminUser", name, status.Threshold, status.Spent, status.Budget, status.Period)
+	}
+	a.checkGlobal(day, cost)
+}
+
+// checkGlobal notifies the admins when the spending of all users crosses a
+// threshold of the global budget. The spending is counted from the usage of
+// all users once per period, then the charges are added to it.
+func (a *BudgetAlerts) checkGlobal(day string, cost float64) {
+	if a.conf.GlobalBudget <= 0 || len(a.conf.BudgetThresholds) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	period := a.conf.BudgetPeriod
+	now := time.Now()
+	start, _ := a.conf.PeriodBounds(period, now)
+	if since := start.Format("2006-01-02"); since != a.spentSince {
+		// The charge is already in the usage
+		a.spent = a.users.TotalCost(a.conf, period)
+		a.spentSince = since
+	} else if a.conf.InPeriod(day, period, now) {
+		a.spent += cost
+	}
+	spent := a.spent
+	key := user.AlertPeriod(period, start)
+	threshold := user.Threshold(a.conf.BudgetThresholds, spent, a.conf.GlobalBudget)
+	if a.global.Period == key && a.global.Threshold == threshold {
+		return
+	}
+	crossed := threshold > 0 && (a.global.Period != key || threshold > a.global.Threshold)
+	a.global = user.BudgetAlert{Period: key, Threshold: threshold}
+	a.save()
+
+	if crossed {
+		a.notifyAdmins(nil, "alerts.adminGlobal", threshold, spent, a.conf.GlobalBudget, period)
+	}
+}
+
+// notifyAdmins sends the message to every admin but except, in their language.
+func (a *BudgetAlerts) notifyAdmins(except *user.UsageTracker, key string, args ...interface{}) {
+	for _, id := range a.conf.AdminChatIDs {
+		adminID := strconv.FormatInt(id, 10)
+		if except != nil && except.UserID == adminID {
+			continue
+		}
+		admin := a.users.GetUser(id, "", a.conf)
+		a.send(adminID, fmt.Sprintf(lang.Translate(key, admin.Lang(a.conf)), args...))
+	}
+}
+
+func (a *BudgetAlerts) send(chatID, text string) {
+	id, err := strconv.ParseInt(chatID, 10, 64)
+	if err != nil {
+		return
+	}
+	msg := tgbotapi.NewMessage(id, text)
+	msg.ParseMode = "HTML"
+	if _, err := a.bot.Send(msg); err != nil {
+		log.Printf("Failed to send budget alert to %d: %v", id, err)
+	}
+}
+
+// save writes the global alert state to disk. a.mu must be held.
+func (a *BudgetAlerts) save() {
+	data, err := json.MarshalIndent(a.global, "", "  ")
+	if err != nil {
+		log.Printf("Error marshalling budget alerts: %v", err)
+		return
+	}
+	if err := os.WriteFile(a.file, data, 0644); err != nil {
+		log.Printf("Error writing budget alerts: %v", err)
+	}
+}
+
+// resetText tells when the budget period of the status ends.
+func resetText(status user.BudgetStatus, userLang string) string {
+	if days, rolling := config.RollingDays(status.Period); rolling {
+		return fmt.Sprintf(lang.Translate("alerts.rolling", userLang), days)
+	}
+	if status.Reset.IsZero() {
+		return lang.Translate("alerts.noReset", userLang)
+	}
+	return fmt.Sprintf(lang.Translate("alerts.reset", userLang), status.Reset.Format("2006-01-02 15:04"))
+}
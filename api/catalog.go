@@ -0,0 +1,201 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"openrouter-bot/config"
+	"sort"
+	"sync"
+	"time"
+)
+
+// catalogTimeout limits a request to the /models endpoint.
+const catalogTimeout = 15 * time.Second
+
+// minCatalogTTL keeps a zero or negative TTL from refreshing the list in a loop.
+const minCatalogTTL = time.Minute
+
+// Catalog caches the models of the API. The list is refreshed in the
+// background once it is older than the TTL, lookups never wait for the network
+// unless nothing has been loaded yet.
+type Catalog struct {
+	baseURL    string
+	ttl        time.Duration
+	client     *http.Client
+	mu         sync.RWMutex
+	models     []Model
+	byID       map[string]Model
+	fetched    time.Time
+	refreshing bool
+}
+
+func NewCatalog(baseURL string, ttl time.Duration) *Catalog {
+	return &Catalog{
+		baseURL: baseURL,
+		ttl:     max(ttl, minCatalogTTL),
+		client:  &http.Client{Timeout: catalogTimeout},
+		byID:    make(map[string]Model),
+	}
+}
+
+// Start loads the models in the background and keeps them fresh.
+func (c *Catalog) Start() {
+	go func() {
+		for {
+			if err := c.Refresh(); err != nil {
+				log.Printf("Error refreshing model catalog: %v", err)
+			}
+			time.Sleep(c.ttl)
+		}
+	}()
+}
+
+// Refresh fetches the models from the API.
+func (c *Catalog) Refresh() error {
+	resp, err := c.client.Get(c.baseURL + "/models")
+	if err != nil {
+		return fmt.Errorf("error get models: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error get models: status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error read response: %v", err)
+	}
+
+	var apiResponse APIResponse
+	err = json.Unmarshal(body, &apiResponse)
+	if err != nil {
+		return fmt.Errorf("error parse json: %v", err)
+	}
+
+	models := apiResponse.Data
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+	byID := make(map[string]Model, len(models))
+	for _, m := range models {
+		byID[m.ID] = m
+	}
+
+	c.mu.Lock()
+	c.models = models
+	c.byID = byID
+	c.fetched = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// Models returns the cached models sorted by ID. They are fetched if the
+// cache is empty, and refreshed in the background if it is stale.
+func (c *Catalog) Models() ([]Model, error) {
+	c.mu.RLock()
+	models, fetched := c.models, c.fetched
+	c.mu.RUnlock()
+
+	if fetched.IsZero() {
+		if err := c.Refresh(); err != nil {
+			return nil, err
+		}
+		c.mu.RLock()
+		models = c.models
+		c.mu.RUnlock()
+	} else if time.Since(fetched) > c.ttl {
+		c.refreshInBackground()
+	}
+	return models, nil
+}
+
+func (c *Catalog) refreshInBackground() {
+	c.mu.Lock()
+	if c.refreshing {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing = true
+	c.mu.Unlock()
+
+	go func() {
+		if err := c.Refresh(); err != nil {
+			log.Printf("Error refreshing model catalog: %v", err)
+		}
+		c.mu.Lock()
+		c.refreshing = false
+		c.mu.Unlock()
+	}()
+}
+
+// Loaded reports whether the catalog has models, lookups of unknown models
+// are only meaningful then.
+func (c *Catalog) Loaded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.models) > 0
+}
+
+// Lookup returns the model with the ID.
+func (c *Catalog) Lookup(id string) (Model, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m, ok := c.byID[id]
+	return m, ok
+}
+
+// Known reports whether the model exists. Every model is considered known
+// while the catalog is not loaded, so that an unreachable API does not block
+// choosing a model.
+func (c *Catalog) Known(id string) bool {
+	if !c.Loaded() {
+		return true
+	}
+	_, ok := c.Lookup(id)
+	return ok
+}
+
+// SupportsVision reports whether the model accepts images. Unknown models are
+// assumed to, the VISION setting decides for them.
+func (c *Catalog) SupportsVision(id string) bool {
+	m, ok := c.Lookup(id)
+	return !ok || m.HasVision()
+}
+
+// Allowed reports whether the role may use the model under model_policies.
+// Models of unknown price are denied to roles with a price limit.
+func (c *Catalog) Allowed(conf *config.Config, role, id string) bool {
+	policy, limited := conf.ModelPolicy(role)
+	if !limited {
+		return true
+	}
+	if !policy.AllowsName(id) {
+		return false
+	}
+	m, ok := c.Lookup(id)
+	if !ok || m.Pricing.Prompt == "" || m.Pricing.Completion == "" {
+		// A model of unknown price could exceed the limit
+		return policy.MaxPrice <= 0
+	}
+	return policy.AllowsPrice(m.PromptPrice(), m.CompletionPrice())
+}
+
+// ContextLength returns the context size of the model, or 0 if it is unknown.
+func (c *Catalog) ContextLength(id string) int {
+	m, _ := c.Lookup(id)
+	return m.ContextLength
+}
+
+// EstimateCost returns the price in USD of a request to the model with the
+// given number of prompt and completion tokens, and whether the model's
+// pricing is known.
+func (c *Catalog) EstimateCost(id string, promptTokens, completionTokens int) (float64, bool) {
+	m, ok := c.Lookup(id)
+	if !ok || m.Pricing.Prompt == "" || m.Pricing.Completion == "" {
+		return 0, false
+	}
+	return (float64(promptTokens)*m.PromptPrice() + float64(completionTokens)*m.CompletionPrice()) / 1e6, true
+}
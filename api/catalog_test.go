@@ -0,0 +1,57 @@
+package api
+
+import (
+	"openrouter-bot/config"
+	"testing"
+)
+
+func testModel(id, prompt, completion string) Model {
+	m := Model{ID: id}
+	m.Pricing.Prompt = prompt
+	m.Pricing.Completion = completion
+	return m
+}
+
+func TestCatalogAllowed(t *testing.T) {
+	catalog := NewCatalog("", 0)
+	for _, m := range []Model{
+		testModel("openai/gpt-4o", "0.0000025", "0.00001"),
+		testModel("openai/gpt-4o-mini", "0.00000015", "0.0000006"),
+		testModel("cheap/prompt-expensive-answer", "0.0000001", "0.0001"),
+		testModel("deepseek/deepseek-chat:free", "0", "0"),
+		testModel("unpriced/model", "", ""),
+	} {
+		catalog.models = append(catalog.models, m)
+		catalog.byID[m.ID] = m
+	}
+	conf := &config.Config{
+		AdminChatIDs: []int64{1},
+		ModelPolicies: map[string]config.ModelPolicy{
+			"USER":  {Deny: []string{"openai/gpt-4o"}, MaxPrice: 5},
+			"GUEST": {Allow: []string{"*:free"}},
+		},
+	}
+
+	tests := []struct {
+		role, model string
+		want        bool
+	}{
+		{"ADMIN", "openai/gpt-4o", true},
+		{"ADMIN", "not/in-catalog", true},
+		{"USER", "openai/gpt-4o", false},
+		{"USER", "openai/gpt-4o-mini", true},
+		{"USER", "cheap/prompt-expensive-answer", false},
+		{"USER", "deepseek/deepseek-chat:free", true},
+		{"USER", "unpriced/model", false},
+		{"USER", "not/in-catalog", false},
+		{"GUEST", "deepseek/deepseek-chat:free", true},
+		{"GUEST", "openai/gpt-4o-mini", false},
+		// No price limit, the patterns alone decide
+		{"GUEST", "other/model:free", true},
+	}
+	for _, tt := range tests {
+		if got := catalog.Allowed(conf, tt.role, tt.model); got != tt.want {
+			t.Errorf("Allowed(%s, %q) = %v, want %v", tt.role, tt.model, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"openrouter-bot/config"
+	"openrouter-bot/lang"
+	"openrouter-bot/user"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	minCompareModels = 2
+	maxCompareModels = 3
+)
+
+// ParseCompareArgs splits "/compare model1 model2 [model3] <prompt>" arguments.
+// Leading words that are model aliases, model IDs ("vendor/model" or
+// "model:tag") or models of the catalog are taken as models, resolved to
+// their IDs.
+func ParseCompareArgs(args string, conf *config.Config, providers *Providers) ([]string, string, bool) {
+	fields := strings.Fields(args)
+	var models []string
+	for len(models) < maxCompareModels && len(models) < len(fields) {
+		field := fields[len(models)]
+		model := conf.ResolveModel(field)
+		if model == field && !strings.ContainsAny(field, "/:") && !providers.knownPlain(field) {
+			break
+		}
+		models = append(models, model)
+	}
+	prompt := strings.TrimSpace(strings.Join(fields[len(models):], " "))
+	if len(models) < minCompareModels || prompt == "" {
+		return nil, "", false
+	}
+	return models, prompt, true
+}
+
+// HandleCompare sends the user's context and prompt to several models at once,
+// posts every answer labeled with the model, latency and cost, and offers to
+// adopt one of them into the history.
+func HandleCompare(bot *tgbotapi.BotAPI, providers *Providers, chatID int64, models []string, prompt string, conf *config.Config, ut *user.UsageTracker) {
+	ut.CheckHistory(conf.MaxHistorySize, conf.MaxHistoryTime)
+
+	statusMsg, err := bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(lang.Translate("compare.running", ut.Lang(conf)), len(models))))
+	if err != nil {
+		log.Printf("Failed to send compare status: %v", err)
+		return
+	}
+
+	messages := append(historyMessages(ut), openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: prompt,
+	})
+
+	answers := make([]user.Message, len(models))
+	var wg sync.WaitGroup
+	var sendMu sync.Mutex
+	for i, model := range models {
+		wg.Add(1)
+		go func(i int, model string) {
+			defer wg.Done()
+			answer, label := compareModel(providers, model, messages, len(models), conf, ut)
+			answers[i] = answer
+
+			// Answers are posted as soon as they arrive, one at a time
+			sendMu.Lock()
+			defer sendMu.Unlock()
+			sendChunkedMessage(bot, chatID, label+"\n\n"+answer.Content, 0)
+		}(i, model)
+	}
+	wg.Wait()
+
+	comparison := &user.Comparison{Prompt: prompt}
+	for _, answer := range answers {
+		if answer.Content != "" {
+			comparison.Answers = append(comparison.Answers, answer)
+		}
+	}
+	if len(comparison.Answers) == 0 {
+		bot.Send(tgbotapi.NewEditMessageText(chatID, statusMsg.MessageID, lang.Translate("errorText", ut.Lang(conf))))
+		return
+	}
+
+	id := ut.AddComparison(comparison)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, answer := range comparison.Answers {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf(lang.Translate("compare.adopt", ut.Lang(conf)), answer.Model),
+			fmt.Sprintf("adopt:%s:%d", id, i))))
+	}
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, statusMsg.MessageID,
+		lang.Translate("compare.choose", ut.Lang(conf)), tgbotapi.NewInlineKeyboardMarkup(rows...))
+	if _, err := bot.Send(edit); err != nil {
+		log.Printf("Failed to send compare buttons: %v", err)
+	}
+}
+
+// compareModel asks one model and returns its answer with a Markdown label.
+// A failed request yields an empty answer and the error in the label. The
+// models run at the same time, so each one gets 1/shares of the budget left.
+func compareModel(providers *Providers, model string, messages []openai.ChatCompletionMessage, shares int, conf *config.Config, ut *user.UsageTracker) (user.Message, string) {
+	req := openai.ChatCompletionRequest{
+		Model:       model,
+		Temperature: float32(conf.Model.Temperature),
+		TopP:        float32(conf.Model.TopP),
+		MaxTokens:   conf.MaxTokens,
+		Messages:    messages,
+	}
+	if est, ok := providers.fitBudgetShare(&req, conf, ut, shares); !ok {
+		return user.Message{}, fmt.Sprintf("`%s` · %s", model, fmt.Sprintf(lang.Translate("estimate.overBudget", ut.Lang(conf)), est.WorstCost, est.Remaining))
+	}
+
+	start := time.Now()
+	completion, provider, err := providers.Complete(context.Background(), req)
+	latency := time.Since(start)
+	if err != nil {
+		log.Printf("Compare error for model %s: %v", model, err)
+		return user.Message{}, fmt.Sprintf("`%s` · %s", model, lang.Translate("errorText", ut.Lang(conf)))
+	}
+
+	answer := user.Message{
+		Role:         openai.ChatMessageRoleAssistant,
+		Content:      completion.Text,
+		Model:        model,
+		Time:         time.Now(),
+		GenerationID: completion.ID,
+	}
+	label := fmt.Sprintf("`%s` · %.1f s", model, latency.Seconds())
+	if cost, err := providers.Charge(context.Background(), provider, completion, ut); err == nil {
+		answer.Cost = cost
+		label += fmt.Sprintf(" · $%.6f", cost)
+	}
+	return answer, label
+}
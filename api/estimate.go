@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"openrouter-bot/config"
+	"openrouter-bot/user"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// minAffordableTokens is the shortest answer worth sending a request for when
+// max_tokens is lowered to fit the remaining budget.
+const minAffordableTokens = 100
+
+// imageTokens is a rough upper bound of the prompt tokens of one image.
+const imageTokens = 1000
+
+// defaultAnswerTokens is the answer length assumed when the request has no
+// max_tokens and the context length of the model is unknown.
+const defaultAnswerTokens = 4096
+
+// CostEstimate is the projected cost of a request before it is sent.
+type CostEstimate struct {
+	Model        string
+	PromptTokens int
+	// MaxTokens is the longest answer, the context length left by the prompt
+	// if the request has no max_tokens, defaultAnswerTokens if that is unknown.
+	MaxTokens int
+	// PromptCost is the cost of the prompt alone, WorstCost the cost with an
+	// answer of MaxTokens.
+	PromptCost float64
+	WorstCost  float64
+	// Remaining is what is left of the user's budget, Limited is false for
+	// users without a budget.
+	Remaining float64
+	Limited   bool
+}
+
+// Affordable returns the answer length that keeps the worst case cost within
+// the remaining budget, and false if not even a short answer is affordable.
+func (e CostEstimate) Affordable() (int, bool) {
+	if !e.Limited || e.WorstCost <= e.Remaining {
+		return e.MaxTokens, true
+	}
+	if e.PromptCost >= e.Remaining || e.MaxTokens == 0 {
+		return 0, false
+	}
+	perToken := (e.WorstCost - e.PromptCost) / float64(e.MaxTokens)
+	tokens := int((e.Remaining - e.PromptCost) / perToken)
+	return tokens, tokens >= min(minAffordableTokens, e.MaxTokens)
+}
+
+// Estimate returns the projected cost of the request for the user.
+func (p *Providers) Estimate(req openai.ChatCompletionRequest, conf *config.Config, ut *user.UsageTracker) CostEstimate {
+	provider, model := p.For(req.Model)
+	est := CostEstimate{
+		Model:        req.Model,
+		PromptTokens: requestTokens(req.Messages),
+		MaxTokens:    req.MaxTokens,
+	}
+	if est.MaxTokens <= 0 {
+		est.MaxTokens = defaultAnswerTokens
+		if contextLength := p.catalog.ContextLength(req.Model); contextLength > 0 {
+			est.MaxTokens = max(contextLength-est.PromptTokens, 0)
+		}
+	}
+
+	c := Completion{Requested: model, Model: model, PromptTokens: est.PromptTokens}
+	est.PromptCost = estimateCost(provider, c)
+	c.CompletionTokens = est.MaxTokens
+	est.WorstCost = estimateCost(provider, c)
+
+	est.Remaining, est.Limited = ut.Remaining(conf)
+	return est
+}
+
+// EstimateNext returns the projected cost of sending text as the next chat
+// message, with the context the message would be sent with. The history is
+// left as it is.
+func (p *Providers) EstimateNext(text string, conf *config.Config, ut *user.UsageTracker) CostEstimate {
+	history := ut.ContextPreview(conf.MaxHistorySize, conf.MaxHistoryTime, conf.MaxContextTokens, contextReserve(ut, text, conf.MaxTokens))
+	messages := append(contextMessages(ut, history), openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: text,
+	})
+	return p.Estimate(chatRequest(ut.Model(conf), messages, conf), conf, ut)
+}
+
+// FitBudget lowers req.MaxTokens so that the worst case cost of the request
+// fits into the user's remaining budget. It returns the estimate and false if
+// the request is not affordable.
+func (p *Providers) FitBudget(req *openai.ChatCompletionRequest, conf *config.Config, ut *user.UsageTracker) (CostEstimate, bool) {
+	return p.fitBudgetShare(req, conf, ut, 1)
+}
+
+// fitBudgetShare is FitBudget for one of shares requests sent at the same
+// time, each of them may spend an equal share of the remaining budget.
+func (p *Providers) fitBudgetShare(req *openai.ChatCompletionRequest, conf *config.Config, ut *user.UsageTracker, shares int) (CostEstimate, bool) {
+	est := p.Estimate(*req, conf, ut)
+	if est.Limited {
+		est.Remaining /= float64(max(shares, 1))
+	}
+	tokens, ok := est.Affordable()
+	if ok && tokens < est.MaxTokens {
+		req.MaxTokens = tokens
+	}
+	return est, ok
+}
+
+// estimateCost prices the token usage without asking the provider: deferred
+// cost providers only know the cost of finished generations.
+func estimateCost(provider Provider, c Completion) float64 {
+	if deferred, ok := provider.(deferredCostProvider); ok {
+		return deferred.Estimate(c)
+	}
+	cost, _ := provider.Cost(context.Background(), c)
+	return cost
+}
+
+// requestTokens estimates the prompt tokens of the messages.
+func requestTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := 0
+	for _, msg := range messages {
+		tokens += user.EstimateTokens(msg.Content)
+		for _, part := range msg.MultiContent {
+			switch part.Type {
+			case openai.ChatMessagePartTypeText:
+				tokens += user.EstimateTokens(part.Text)
+			case openai.ChatMessagePartTypeImageURL:
+				tokens += imageTokens
+			}
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"openrouter-bot/config"
+	"openrouter-bot/user"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestAffordable(t *testing.T) {
+	tests := []struct {
+		name   string
+		est    CostEstimate
+		tokens int
+		ok     bool
+	}{
+		{"no budget", CostEstimate{MaxTokens: 1000, WorstCost: 10, Limited: false}, 1000, true},
+		{"within budget", CostEstimate{MaxTokens: 1000, PromptCost: 0.1, WorstCost: 1.1, Remaining: 2, Limited: true}, 1000, true},
+		{"exactly the budget", CostEstimate{MaxTokens: 1000, PromptCost: 0.1, WorstCost: 1.1, Remaining: 1.1, Limited: true}, 1000, true},
+		{"lowered", CostEstimate{MaxTokens: 1000, PromptCost: 0.1, WorstCost: 1.1, Remaining: 0.6, Limited: true}, 500, true},
+		{"too short to be worth it", CostEstimate{MaxTokens: 1024, WorstCost: 1, Remaining: 0.03125, Limited: true}, 32, false},
+		{"short max_tokens", CostEstimate{MaxTokens: 50, PromptCost: 0, WorstCost: 1, Remaining: 0.9, Limited: true}, 45, false},
+		{"prompt over budget", CostEstimate{MaxTokens: 1000, PromptCost: 1, WorstCost: 2, Remaining: 0.5, Limited: true}, 0, false},
+		{"no room for an answer", CostEstimate{MaxTokens: 0, PromptCost: 0.1, WorstCost: 0.2, Remaining: 0.15, Limited: true}, 0, false},
+		{"budget exhausted", CostEstimate{MaxTokens: 1000, WorstCost: 1, Remaining: -1, Limited: true}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, ok := tt.est.Affordable()
+			if tokens != tt.tokens || ok != tt.ok {
+				t.Errorf("Affordable() = %d, %v, want %d, %v", tokens, ok, tt.tokens, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFitBudgetShare(t *testing.T) {
+	conf := &config.Config{BudgetPeriod: config.PeriodDaily}
+	// $100 per million completion tokens, the prompt is free: an answer of
+	// defaultAnswerTokens costs $0.4096
+	prices := pricing{prices: map[string]config.ModelPrice{"test/model": {Completion: 100}}}
+	providers := &Providers{
+		conf:     conf,
+		catalog:  NewCatalog("", 0),
+		fallback: newOpenAIProvider("", "", prices),
+	}
+
+	tests := []struct {
+		name      string
+		budget    float64
+		maxTokens int
+		shares    int
+		want      int
+		ok        bool
+	}{
+		{"unknown context length is not free", 0.2, 0, 1, 2000, true},
+		{"affordable", 1, 0, 1, 0, true},
+		{"half of the budget each", 1, 0, 2, 0, true},
+		{"a third of the budget each", 1, 0, 3, 3333, true},
+		{"max_tokens kept", 1, 1000, 1, 1000, true},
+		{"max_tokens lowered", 0.05, 1000, 1, 500, true},
+		{"not affordable", 0.005, 0, 1, 0, false},
+		{"not affordable once shared", 0.015, 0, 2, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf.GuestBudget = tt.budget
+			ut := user.NewUsageTracker("1", "", t.TempDir(), conf)
+			req := openai.ChatCompletionRequest{
+				Model:     "test/model",
+				MaxTokens: tt.maxTokens,
+				Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hello"}},
+			}
+			_, ok := providers.fitBudgetShare(&req, conf, ut, tt.shares)
+			if ok != tt.ok {
+				t.Fatalf("fitBudgetShare() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && req.MaxTokens != tt.want {
+				t.Errorf("MaxTokens = %d, want %d", req.MaxTokens, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"openrouter-bot/lang"
+	"openrouter-bot/user"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const summaryLength = 500
+
+var (
+	fileNameRe = regexp.MustCompile(`[\w.-]+\.[A-Za-z0-9]{1,10}`)
+
+	languageExtensions = map[string]string{
+		"bash": "sh", "sh": "sh", "shell": "sh", "zsh": "sh", "powershell": "ps1", "ps1": "ps1",
+		"c": "c", "cpp": "cpp", "c++": "cpp", "csharp": "cs", "cs": "cs", "go": "go", "golang": "go",
+		"java": "java", "kotlin": "kt", "swift": "swift", "rust": "rs", "python": "py", "py": "py",
+		"ruby": "rb", "php": "php", "javascript": "js", "js": "js", "jsx": "jsx", "typescript": "ts",
+		"ts": "ts", "tsx": "tsx", "html": "html", "css": "css", "scss": "scss", "sql": "sql",
+		"json": "json", "yaml": "yaml", "yml": "yml", "toml": "toml", "xml": "xml", "ini": "ini",
+		"dockerfile": "Dockerfile", "makefile": "Makefile", "markdown": "md", "md": "md", "lua": "lua",
+		"r": "r", "scala": "scala", "dart": "dart", "perl": "pl", "haskell": "hs", "elixir": "ex",
+		"text": "txt", "txt": "txt", "diff": "diff", "proto": "proto", "graphql": "graphql",
+	}
+)
+
+type codeFile struct {
+	name    string
+	content string
+}
+
+// sendResponse delivers the model answer according to the user's file mode:
+// as formatted text, as a single Markdown document with a short summary, or
+// with every fenced code block that has a language tag attached as a file.
+func sendResponse(bot *tgbotapi.BotAPI, chatID int64, text string, messageID int, mode string, threshold int, language string) {
+	if mode == user.FileModeOff || len([]rune(text)) <= threshold {
+		sendChunkedMessage(bot, chatID, text, messageID)
+		return
+	}
+
+	switch mode {
+	case user.FileModeLong:
+		summary := summarize(text) + "\n\n" + fmt.Sprintf(lang.Translate("files.attached", language), len([]rune(text)))
+		sendChunkedMessage(bot, chatID, summary, messageID)
+		sendFile(bot, chatID, codeFile{name: "answer.md", content: text})
+	case user.FileModeCode:
+		remaining, files := extractCodeFiles(text)
+		if len(files) == 0 {
+			sendChunkedMessage(bot, chatID, text, messageID)
+			return
+		}
+		sendChunkedMessage(bot, chatID, remaining, messageID)
+		for _, file := range files {
+			sendFile(bot, chatID, file)
+		}
+	default:
+		log.Printf("Unknown file mode %q, sending answer as text", mode)
+		sendChunkedMessage(bot, chatID, text, messageID)
+	}
+}
+
+func sendFile(bot *tgbotapi.BotAPI, chatID int64, file codeFile) {
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: file.name, Bytes: []byte(file.content)})
+	if _, err := bot.Send(doc); err != nil {
+		log.Printf("Failed to send file %s: %v", file.name, err)
+	}
+}
+
+// summarize returns the leading prose of the answer, cut to summaryLength
+// characters and stopping at the first code block.
+func summarize(text string) string {
+	if idx := strings.Index(text, "```"); idx >= 0 {
+		text = text[:idx]
+	}
+	text = strings.TrimSpace(text)
+	runes := []rune(text)
+	if len(runes) <= summaryLength {
+		return text
+	}
+	cut := string(runes[:summaryLength])
+	if idx := strings.LastIndex(cut, "\n\n"); idx > summaryLength/2 {
+		return cut[:idx]
+	}
+	if idx := strings.LastIndexAny(cut, ".!?"); idx > summaryLength/2 {
+		return cut[:idx+1]
+	}
+	return cut + "…"
+}
+
+// extractCodeFiles moves fenced code blocks with a language tag out of the
+// text and replaces each of them with a reference to the attached file.
+func extractCodeFiles(text string) (string, []codeFile) {
+	lines := strings.Split(text, "\n")
+	var out []string
+	var files []codeFile
+	used := make(map[string]bool)
+
+	for i := 0; i < len(lines); i++ {
+		m := fenceRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			out = append(out, lines[i])
+			continue
+		}
+		fence, language := m[1], strings.ToLower(m[2])
+		start := i
+		var code []string
+		for i++; i < len(lines) && !isClosingFence(lines[i], fence); i++ {
+			code = append(code, lines[i])
+		}
+		if language == "" {
+			end := min(i, len(lines)-1)
+			out = append(out, lines[start:end+1]...)
+			continue
+		}
+
+		name := codeFileName(language, len(files)+1, out, used)
+		used[name] = true
+		files = append(files, codeFile{name: name, content: strings.Join(code, "\n") + "\n"})
+		out = append(out, "📎 `"+name+"`")
+	}
+	return strings.Join(out, "\n"), files
+}
+
+// codeFileName picks a file name for a code block: a name mentioned on the
+// line right before the block (e.g. "**main.go**") if it has the expected
+// extension, or snippet_N with an extension derived from the language tag.
+func codeFileName(language string, n int, before []string, used map[string]bool) string {
+	ext, ok := languageExtensions[language]
+	if !ok {
+		ext = "txt"
+	}
+
+	for i := len(before) - 1; i >= 0; i-- {
+		if strings.TrimSpace(before[i]) == "" {
+			continue
+		}
+		for _, candidate := range fileNameRe.FindAllString(before[i], -1) {
+			if strings.HasSuffix(candidate, "."+ext) && !used[candidate] {
+				return candidate
+			}
+		}
+		break
+	}
+
+	if ext == "Dockerfile" || ext == "Makefile" {
+		if !used[ext] {
+			return ext
+		}
+		return fmt.Sprintf("%s_%d", ext, n)
+	}
+	return fmt.Sprintf("snippet_%d.%s", n, ext)
+}
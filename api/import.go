@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"openrouter-bot/config"
+	"openrouter-bot/lang"
+	"openrouter-bot/user"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxImportSize = 1 << 20
+
+// HandleImport loads a transcript sent as a document into the user's history
+// as a new conversation, optionally named, so the next message continues it.
+func HandleImport(bot *tgbotapi.BotAPI, chatID int64, doc *tgbotapi.Document, name string, conf *config.Config, ut *user.UsageTracker) {
+	reply := func(key string, args ...interface{}) {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(lang.Translate(key, ut.Lang(conf)), args...))
+		msg.ParseMode = tgbotapi.ModeHTML
+		if _, err := bot.Send(msg); err != nil {
+			log.Printf("Failed to send import result: %v", err)
+		}
+	}
+
+	if doc == nil {
+		reply("import.usage")
+		return
+	}
+	if doc.FileSize > maxImportSize {
+		reply("import.fileTooLarge", maxImportSize>>20)
+		return
+	}
+
+	data, err := downloadFile(bot, doc.FileID)
+	if err != nil {
+		log.Printf("Failed to download import for user %s: %v", ut.UserID, err)
+		reply("import.error")
+		return
+	}
+
+	conv, err := user.ParseTranscript(doc.FileName, data, conf.MaxContextTokens)
+	switch {
+	case err == nil:
+	case errors.Is(err, user.ErrImportFormat):
+		reply("import.format")
+		return
+	case errors.Is(err, user.ErrImportEmpty):
+		reply("import.empty")
+		return
+	case errors.Is(err, user.ErrImportRole):
+		reply("import.role")
+		return
+	case errors.Is(err, user.ErrImportTooLarge):
+		reply("import.tooLarge", conf.MaxContextTokens)
+		return
+	default:
+		log.Printf("Failed to parse import for user %s: %v", ut.UserID, err)
+		reply("import.error")
+		return
+	}
+
+	tokens := user.EstimateTokens(conv.SystemPrompt)
+	for _, msg := range conv.Messages {
+		tokens += user.EstimateTokens(msg.Content)
+	}
+	loaded := ut.ImportConversation(name, conv.SystemPrompt, conv.Messages, conf.MaxHistorySize)
+	reply("import.done", len(conv.Messages), tokens, loaded)
+}
+
+func downloadFile(bot *tgbotapi.BotAPI, fileID string) ([]byte, error) {
+	url, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxImportSize))
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"openrouter-bot/config"
+	"openrouter-bot/lang"
+	"openrouter-bot/user"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/sashabaranov/go-openai"
+)
+
+// inlineTimeout limits the model request of an inline query: Telegram only
+// accepts an answer for a short while, a later one fails and is not shown.
+const inlineTimeout = 15 * time.Second
+
+// errOverBudget is returned when the remaining budget cannot pay for the answer.
+var errOverBudget = errors.New("not enough budget for the request")
+
+// InlineResponder answers inline queries (@bot question) once the user stops typing.
+type InlineResponder struct {
+	bot       *tgbotapi.BotAPI
+	providers *Providers
+	mu        sync.Mutex
+	pending   map[int64]inlineRequest
+	counter   uint64
+}
+
+type inlineRequest struct {
+	seq    uint64
+	cancel context.CancelFunc
+}
+
+func NewInlineResponder(bot *tgbotapi.BotAPI, providers *Providers) *InlineResponder {
+	return &InlineResponder{
+		bot:       bot,
+		providers: providers,
+		pending:   make(map[int64]inlineRequest),
+	}
+}
+
+// Handle schedules an answer for the query. Telegram sends a new query on every
+// keystroke, so a pending request of the same user is cancelled and only the
+// last one is sent to the model after the debounce delay.
+func (ir *InlineResponder) Handle(query *tgbotapi.InlineQuery, conf *config.Config, user *user.UsageTracker) {
+	text := strings.TrimSpace(query.Query)
+
+	ir.mu.Lock()
+	if prev, ok := ir.pending[query.From.ID]; ok {
+		prev.cancel()
+		delete(ir.pending, query.From.ID)
+	}
+	if text == "" {
+		ir.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), inlineTimeout)
+	ir.counter++
+	seq := ir.counter
+	ir.pending[query.From.ID] = inlineRequest{seq: seq, cancel: cancel}
+	ir.mu.Unlock()
+
+	go func() {
+		defer ir.done(query.From.ID, seq)
+
+		select {
+		case <-time.After(time.Duration(conf.InlineDebounce) * time.Millisecond):
+		case <-ctx.Done():
+			return
+		}
+
+		if !user.HaveAccess(conf) {
+			ir.answer(query.ID, text, lang.Translate("budget_out", user.Lang(conf)))
+			return
+		}
+		if !ir.providers.catalog.Allowed(conf, user.GetUserRole(conf), conf.InlineModel) {
+			ir.answer(query.ID, text, fmt.Sprintf(lang.Translate("inline.modelNotAllowed", user.Lang(conf)), conf.InlineModel))
+			return
+		}
+
+		completion, provider, err := ir.generate(ctx, text, conf, user)
+		if errors.Is(err, errOverBudget) {
+			ir.answer(query.ID, text, lang.Translate("budget_out", user.Lang(conf)))
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Inline completion error for user %s: %v", user.UserID, err)
+			}
+			return
+		}
+		ir.answer(query.ID, text, completion.Text)
+		ir.providers.Charge(context.Background(), provider, completion, user)
+	}()
+}
+
+func (ir *InlineResponder) done(userID int64, seq uint64) {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	if req, ok := ir.pending[userID]; ok && req.seq == seq {
+		req.cancel()
+		delete(ir.pending, userID)
+	}
+}
+
+func (ir *InlineResponder) generate(ctx context.Context, text string, conf *config.Config, user *user.UsageTracker) (Completion, Provider, error) {
+	req := openai.ChatCompletionRequest{
+		Model:       conf.InlineModel,
+		Temperature: float32(conf.Model.Temperature),
+		TopP:        float32(conf.Model.TopP),
+		MaxTokens:   conf.InlineMaxTokens,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: user.SystemPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: text},
+		},
+	}
+
+	if _, ok := ir.providers.FitBudget(&req, conf, user); !ok {
+		return Completion{}, nil, errOverBudget
+	}
+	return ir.providers.Complete(ctx, req)
+}
+
+func (ir *InlineResponder) answer(queryID, title, text string) {
+	const maxMessageLength = 4096
+	if runes := []rune(text); len(runes) > maxMessageLength {
+		text = string(runes[:maxMessageLength-1]) + "…"
+	}
+
+	article := tgbotapi.NewInlineQueryResultArticle(queryID, title, text)
+	article.Description = text
+
+	inlineConf := tgbotapi.InlineConfig{
+		InlineQueryID: queryID,
+		Results:       []interface{}{article},
+		IsPersonal:    true,
+	}
+	if _, err := ir.bot.Request(inlineConf); err != nil {
+		log.Printf("Failed to answer inline query: %v", err)
+	}
+}
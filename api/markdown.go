@@ -0,0 +1,286 @@
+package api
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	headingRe = regexp.MustCompile(`^ {0,3}#{1,6}\s+(.*?)(?:\s+#+)?\s*$`)
+	bulletRe  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedRe = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	quoteRe   = regexp.MustCompile(`^ {0,3}>\s?(.*)$`)
+	ruleRe    = regexp.MustCompile(`^ {0,3}([-*_])\s*(?:\s*([-*_])\s*){2,}$`)
+	fenceRe   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([\\w+#.-]*)")
+	taskRe    = regexp.MustCompile(`^\[([ xX])\]\s+`)
+)
+
+// MarkdownToHTML converts the CommonMark subset produced by LLMs into the HTML
+// dialect accepted by Telegram (parse_mode=HTML). Every tag it emits is closed,
+// and everything else is escaped, so unbalanced markers in the source end up
+// as plain characters instead of breaking the whole message.
+func MarkdownToHTML(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var out []string
+	var quote []string
+
+	flushQuote := func() {
+		if len(quote) > 0 {
+			out = append(out, "<blockquote>"+strings.Join(quote, "\n")+"</blockquote>")
+			quote = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := fenceRe.FindStringSubmatch(line); m != nil {
+			flushQuote()
+			fence, language := m[1], m[2]
+			var code []string
+			for i++; i < len(lines); i++ {
+				if isClosingFence(lines[i], fence) {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			out = append(out, codeBlockHTML(strings.Join(code, "\n"), language))
+			continue
+		}
+
+		if m := quoteRe.FindStringSubmatch(line); m != nil {
+			quote = append(quote, renderBlockLine(m[1]))
+			continue
+		}
+		flushQuote()
+		out = append(out, renderBlockLine(line))
+	}
+	flushQuote()
+
+	return strings.Join(out, "\n")
+}
+
+func isClosingFence(line, fence string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == ""
+}
+
+func codeBlockHTML(code, language string) string {
+	if language == "" {
+		return "<pre>" + html.EscapeString(code) + "</pre>"
+	}
+	return `<pre><code class="language-` + html.EscapeString(language) + `">` + html.EscapeString(code) + "</code></pre>"
+}
+
+func renderBlockLine(line string) string {
+	if ruleRe.MatchString(line) {
+		return "——————"
+	}
+	if m := headingRe.FindStringSubmatch(line); m != nil {
+		return "<b>" + renderInline(m[1]) + "</b>"
+	}
+	if m := bulletRe.FindStringSubmatch(line); m != nil {
+		item := m[2]
+		marker := "•"
+		if t := taskRe.FindStringSubmatch(item); t != nil {
+			marker = "☐"
+			if t[1] != " " {
+				marker = "☑"
+			}
+			item = item[len(t[0]):]
+		}
+		return m[1] + marker + " " + renderInline(item)
+	}
+	if m := orderedRe.FindStringSubmatch(line); m != nil {
+		return m[1] + m[2] + ". " + renderInline(m[3])
+	}
+	return renderInline(line)
+}
+
+// inlineMarkers maps Markdown emphasis delimiters to Telegram HTML tags,
+// longest delimiters first so that "**" wins over "*".
+var inlineMarkers = []struct {
+	delim string
+	tag   string
+}{
+	{"**", "b"},
+	{"__", "b"},
+	{"~~", "s"},
+	{"*", "i"},
+	{"_", "i"},
+}
+
+func renderInline(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case r == '\\' && i+1 < len(runes) && (unicode.IsPunct(runes[i+1]) || unicode.IsSymbol(runes[i+1])):
+			b.WriteString(html.EscapeString(string(runes[i+1])))
+			i += 2
+			continue
+
+		case r == '`':
+			if end, width := findCodeSpan(runes, i); end > 0 {
+				code := strings.TrimSpace(string(runes[i+width : end]))
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i = end + width
+				continue
+			}
+
+		case r == '[':
+			if label, url, next, ok := parseLink(runes, i); ok {
+				b.WriteString(`<a href="` + html.EscapeString(url) + `">` + renderInline(label) + "</a>")
+				i = next
+				continue
+			}
+
+		case r == '<':
+			if end := indexRune(runes, i+1, '>'); end > 0 {
+				target := string(runes[i+1 : end])
+				if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+					b.WriteString(`<a href="` + html.EscapeString(target) + `">` + html.EscapeString(target) + "</a>")
+					i = end + 1
+					continue
+				}
+			}
+		}
+
+		if tag, inner, next, ok := parseEmphasis(runes, i); ok {
+			b.WriteString("<" + tag + ">" + renderInline(inner) + "</" + tag + ">")
+			i = next
+			continue
+		}
+
+		b.WriteString(html.EscapeString(string(r)))
+		i++
+	}
+	return b.String()
+}
+
+// findCodeSpan returns the index of the backtick run closing the code span
+// opened at start and the width of the run, or -1 if the span is not closed.
+func findCodeSpan(runes []rune, start int) (int, int) {
+	width := 0
+	for start+width < len(runes) && runes[start+width] == '`' {
+		width++
+	}
+	for i := start + width; i < len(runes); i++ {
+		if runes[i] != '`' {
+			continue
+		}
+		n := 0
+		for i+n < len(runes) && runes[i+n] == '`' {
+			n++
+		}
+		if n == width {
+			return i, width
+		}
+		i += n - 1
+	}
+	return -1, width
+}
+
+func parseLink(runes []rune, start int) (string, string, int, bool) {
+	depth := 0
+	closeLabel := -1
+	for i := start; i < len(runes); i++ {
+		if runes[i] == '[' {
+			depth++
+		} else if runes[i] == ']' {
+			depth--
+			if depth == 0 {
+				closeLabel = i
+				break
+			}
+		}
+	}
+	if closeLabel < 0 || closeLabel+1 >= len(runes) || runes[closeLabel+1] != '(' {
+		return "", "", 0, false
+	}
+	closeURL := indexRune(runes, closeLabel+2, ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	url := strings.TrimSpace(string(runes[closeLabel+2 : closeURL]))
+	// Drop an optional link title: [text](url "title")
+	if sp := strings.IndexAny(url, " \t"); sp > 0 {
+		url = url[:sp]
+	}
+	if url == "" || strings.ContainsAny(url, "<>\"") {
+		return "", "", 0, false
+	}
+	return string(runes[start+1 : closeLabel]), url, closeURL + 1, true
+}
+
+// parseEmphasis recognises an emphasis span starting at i. Delimiters must hug
+// the text ("*a*", not "* a *") and underscores inside words (snake_case) are
+// never treated as markup.
+func parseEmphasis(runes []rune, i int) (string, string, int, bool) {
+	for _, m := range inlineMarkers {
+		delim := []rune(m.delim)
+		if !hasPrefixAt(runes, i, delim) {
+			continue
+		}
+		open := i + len(delim)
+		if open >= len(runes) || unicode.IsSpace(runes[open]) {
+			return "", "", 0, false
+		}
+		if delim[0] == '_' && i > 0 && isWordRune(runes[i-1]) {
+			return "", "", 0, false
+		}
+		for j := open + 1; j+len(delim) <= len(runes); j++ {
+			if runes[j] == '`' {
+				if end, width := findCodeSpan(runes, j); end > 0 {
+					j = end + width - 1
+					continue
+				}
+			}
+			if !hasPrefixAt(runes, j, delim) || unicode.IsSpace(runes[j-1]) {
+				continue
+			}
+			// "**" must not close a single "*" span and vice versa.
+			if len(delim) == 1 && j+1 < len(runes) && runes[j+1] == delim[0] {
+				j++
+				continue
+			}
+			after := j + len(delim)
+			if delim[0] == '_' && after < len(runes) && isWordRune(runes[after]) {
+				continue
+			}
+			return m.tag, string(runes[open:j]), after, true
+		}
+		return "", "", 0, false
+	}
+	return "", "", 0, false
+}
+
+func hasPrefixAt(runes []rune, i int, prefix []rune) bool {
+	if i+len(prefix) > len(runes) {
+		return false
+	}
+	for k, r := range prefix {
+		if runes[i+k] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
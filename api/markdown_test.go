@@ -0,0 +1,46 @@
+package api
+
+import "testing"
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"escapes html", "plain & <text>", "plain &amp; &lt;text&gt;"},
+		{"emphasis", "**bold** and *italic* and __b__ and _i_ and ~~s~~",
+			"<b>bold</b> and <i>italic</i> and <b>b</b> and <i>i</i> and <s>s</s>"},
+		{"underscores inside words", "snake_case_name", "snake_case_name"},
+		{"unclosed emphasis", "**unclosed bold", "**unclosed bold"},
+		{"emphasis must hug the text", "a * b * c", "a * b * c"},
+		{"code spans", "`a < b` and ``x ` y``", "<code>a &lt; b</code> and <code>x ` y</code>"},
+		{"delimiter inside code span", "*a `*` b*", "<i>a <code>*</code> b</i>"},
+		{"link with title", `[link](https://example.com "title")`, `<a href="https://example.com">link</a>`},
+		{"link with markup in the label", "[**bold**](https://e.com)", `<a href="https://e.com"><b>bold</b></a>`},
+		{"invalid link", "[bad](<x>)", "[bad](&lt;x&gt;)"},
+		{"autolink", "<https://example.com/?a=1&b=2>",
+			`<a href="https://example.com/?a=1&amp;b=2">https://example.com/?a=1&amp;b=2</a>`},
+		{"not an autolink", "<b>", "&lt;b&gt;"},
+		{"backslash escapes", `\*escaped\* and \_ and \<`, "*escaped* and _ and &lt;"},
+		{"heading", "# Title #", "<b>Title</b>"},
+		{"lists", "- item\n  * nested\n+ [x] done\n- [ ] todo\n1. one\n2) two",
+			"• item\n  • nested\n☑ done\n☐ todo\n1. one\n2. two"},
+		{"blockquote", "> quote\n> **second**\nafter", "<blockquote>quote\n<b>second</b></blockquote>\nafter"},
+		{"horizontal rules", "---\n* * *", "——————\n——————"},
+		{"code block", "```go\nif a < b {}\n```", `<pre><code class="language-go">if a &lt; b {}</code></pre>`},
+		{"code block is not formatted", "```\n**a** _b_\n```", "<pre>**a** _b_</pre>"},
+		{"unclosed code block", "```\nunclosed <code>", "<pre>unclosed &lt;code&gt;</pre>"},
+		{"shorter fence does not close", "~~~~\n~~~\n~~~~", "<pre>~~~</pre>"},
+		{"code block ends a quote", "> a\n```\nb\n```", "<blockquote>a</blockquote>\n<pre>b</pre>"},
+		{"windows line endings", "a\r\nb", "a\nb"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MarkdownToHTML(tt.in); got != tt.want {
+				t.Errorf("MarkdownToHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
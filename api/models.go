@@ -0,0 +1,368 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"openrouter-bot/config"
+	"openrouter-bot/lang"
+	"openrouter-bot/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// modelsPageSize is the number of models on a page of the browser.
+const modelsPageSize = 8
+
+// PromptPrice returns the price of a million prompt tokens in USD.
+func (m Model) PromptPrice() float64 {
+	return perMillion(m.Pricing.Prompt)
+}
+
+// CompletionPrice returns the price of a million completion tokens in USD.
+func (m Model) CompletionPrice() float64 {
+	return perMillion(m.Pricing.Completion)
+}
+
+// IsFree reports whether both prompt and completion tokens are free.
+func (m Model) IsFree() bool {
+	return m.PromptPrice() == 0 && m.CompletionPrice() == 0
+}
+
+// Supports reports whether the model accepts the request parameter, for
+// example "tools" or "temperature". Models that do not list their parameters
+// are assumed to support it.
+func (m Model) Supports(param string) bool {
+	if len(m.SupportedParameters) == 0 {
+		return true
+	}
+	for _, p := range m.SupportedParameters {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// HasVision reports whether the model accepts images.
+func (m Model) HasVision() bool {
+	for _, modality := range m.Architecture.InputModalities {
+		if modality == "image" {
+			return true
+		}
+	}
+	input, _, _ := strings.Cut(m.Architecture.Modality, "->")
+	return strings.Contains(input, "image")
+}
+
+// perMillion converts a per-token price string of the API to USD per million tokens.
+func perMillion(price string) float64 {
+	p, err := strconv.ParseFloat(price, 64)
+	if err != nil || p < 0 {
+		return 0
+	}
+	return p * 1e6
+}
+
+// ModelFilter selects models in the browser.
+type ModelFilter struct {
+	Query      []string
+	Free       bool
+	Vision     bool
+	MinContext int
+	// MaxPrice is the maximum price of a million prompt tokens, 0 means any
+	MaxPrice float64
+}
+
+// ParseModelFilter parses "/get_models" arguments: the words "free" and
+// "vision", "ctx>=N" (N may end with k), "price<=X" in USD per million prompt
+// tokens, and any other words to search in the model ID and name. Without
+// arguments only free models are shown.
+func ParseModelFilter(args string) ModelFilter {
+	words := strings.Fields(strings.ToLower(args))
+	if len(words) == 0 {
+		return ModelFilter{Free: true}
+	}
+
+	var f ModelFilter
+	for _, word := range words {
+		switch {
+		case word == "free":
+			f.Free = true
+		case word == "vision":
+			f.Vision = true
+		case strings.HasPrefix(word, "ctx>="):
+			n := strings.TrimPrefix(word, "ctx>=")
+			multiplier := 1
+			if strings.HasSuffix(n, "k") {
+				n, multiplier = strings.TrimSuffix(n, "k"), 1000
+			}
+			if v, err := strconv.Atoi(n); err == nil {
+				f.MinContext = v * multiplier
+				continue
+			}
+			f.Query = append(f.Query, word)
+		case strings.HasPrefix(word, "price<="):
+			if v, err := strconv.ParseFloat(strings.TrimPrefix(word, "price<="), 64); err == nil {
+				f.MaxPrice = v
+				continue
+			}
+			f.Query = append(f.Query, word)
+		default:
+			f.Query = append(f.Query, word)
+		}
+	}
+	return f
+}
+
+// Match reports whether the model passes the filter.
+func (f ModelFilter) Match(m Model) bool {
+	switch {
+	case f.Free && !m.IsFree():
+		return false
+	case f.Vision && !m.HasVision():
+		return false
+	case m.ContextLength < f.MinContext:
+		return false
+	case f.MaxPrice > 0 && m.PromptPrice() > f.MaxPrice:
+		return false
+	}
+	text := strings.ToLower(m.ID + " " + m.Name)
+	for _, word := range f.Query {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the filter for the browser header.
+func (f ModelFilter) String() string {
+	var parts []string
+	if f.Free {
+		parts = append(parts, "free")
+	}
+	if f.Vision {
+		parts = append(parts, "vision")
+	}
+	if f.MinContext > 0 {
+		parts = append(parts, fmt.Sprintf("ctx>=%d", f.MinContext))
+	}
+	if f.MaxPrice > 0 {
+		parts = append(parts, fmt.Sprintf("price<=%g", f.MaxPrice))
+	}
+	parts = append(parts, f.Query...)
+	return strings.Join(parts, " ")
+}
+
+// ModelBrowser shows the models as a paginated list with inline buttons. The
+// state of the last list opened by every user is kept in memory, callbacks
+// start with "mb:".
+type ModelBrowser struct {
+	bot     *tgbotapi.BotAPI
+	catalog *Catalog
+	mu      sync.Mutex
+	states  map[int64]*browserState
+}
+
+type browserState struct {
+	models  []Model
+	shown   []Model
+	filter  ModelFilter
+	page    int
+	details int
+}
+
+func NewModelBrowser(bot *tgbotapi.BotAPI, catalog *Catalog) *ModelBrowser {
+	return &ModelBrowser{
+		bot:     bot,
+		catalog: catalog,
+		states:  make(map[int64]*browserState),
+	}
+}
+
+// Open sends the first page of the models matching args.
+func (mb *ModelBrowser) Open(chatID, userID int64, args string, language string) {
+	models, err := mb.catalog.Models()
+	if err != nil {
+		log.Printf("Error fetching models: %v", err)
+		mb.bot.Send(tgbotapi.NewMessage(chatID, lang.Translate("errorText", language)))
+		return
+	}
+
+	state := &browserState{models: models, filter: ParseModelFilter(args)}
+	state.apply()
+
+	mb.mu.Lock()
+	mb.states[userID] = state
+	text, markup := state.render(language)
+	mb.mu.Unlock()
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "HTML"
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	if _, err := mb.bot.Send(msg); err != nil {
+		log.Printf("Failed to send models: %v", err)
+	}
+}
+
+// HandleCallback handles a "mb:" callback of the browser message and returns
+// the text of the callback answer. "Use this model" sets the model of the
+// user like /set_model.
+func (mb *ModelBrowser) HandleCallback(query *tgbotapi.CallbackQuery, data string, conf *config.Config, ut *user.UsageTracker, language string) string {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	state := mb.states[query.From.ID]
+	if state == nil || query.Message == nil {
+		return lang.Translate("models.expired", language)
+	}
+
+	action, arg, _ := strings.Cut(data, ":")
+	n, _ := strconv.Atoi(arg)
+	answer := ""
+	switch action {
+	case "p":
+		state.page = n
+		state.details = -1
+	case "f":
+		switch arg {
+		case "free":
+			state.filter.Free = !state.filter.Free
+		case "vision":
+			state.filter.Vision = !state.filter.Vision
+		}
+		state.apply()
+	case "m":
+		if n < 0 || n >= len(state.shown) {
+			return lang.Translate("models.expired", language)
+		}
+		state.details = n
+	case "u":
+		if n < 0 || n >= len(state.shown) {
+			return lang.Translate("models.expired", language)
+		}
+		model := state.shown[n].ID
+		if !mb.catalog.Allowed(conf, ut.GetUserRole(conf), model) {
+			return lang.Translate("models.notAllowed", language)
+		}
+		ut.SetModel(model)
+		answer = lang.Translate("commands.setModel", language) + " " + model
+	case "b":
+		state.details = -1
+	}
+
+	text, markup := state.render(language)
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ParseMode = "HTML"
+	edit.ReplyMarkup = markup
+	if _, err := mb.bot.Send(edit); err != nil {
+		log.Printf("Failed to update models: %v", err)
+	}
+	return answer
+}
+
+// apply filters the models and returns to the first page of the list.
+func (s *browserState) apply() {
+	s.shown = nil
+	for _, m := range s.models {
+		if s.filter.Match(m) {
+			s.shown = append(s.shown, m)
+		}
+	}
+	s.page = 0
+	s.details = -1
+}
+
+func (s *browserState) render(language string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	if s.details >= 0 && s.details < len(s.shown) {
+		return s.renderDetails(language)
+	}
+	return s.renderList(language)
+}
+
+func (s *browserState) renderList(language string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	pages := (len(s.shown) + modelsPageSize - 1) / modelsPageSize
+	if s.page >= pages {
+		s.page = pages - 1
+	}
+	if s.page < 0 {
+		s.page = 0
+	}
+
+	text := fmt.Sprintf(lang.Translate("models.list", language), len(s.shown), html.EscapeString(s.filter.String()))
+	if len(s.shown) == 0 {
+		text += "\n\n" + lang.Translate("models.notFound", language)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := s.page * modelsPageSize; i < len(s.shown) && i < (s.page+1)*modelsPageSize; i++ {
+		m := s.shown[i]
+		label := m.ID
+		if !m.IsFree() {
+			label += fmt.Sprintf(" · $%g", m.PromptPrice())
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("mb:m:%d", i))))
+	}
+
+	if pages > 1 {
+		var nav []tgbotapi.InlineKeyboardButton
+		if s.page > 0 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀", fmt.Sprintf("mb:p:%d", s.page-1)))
+		}
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", s.page+1, pages), fmt.Sprintf("mb:p:%d", s.page)))
+		if s.page < pages-1 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("▶", fmt.Sprintf("mb:p:%d", s.page+1)))
+		}
+		rows = append(rows, nav)
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(toggleLabel(lang.Translate("models.free", language), s.filter.Free), "mb:f:free"),
+		tgbotapi.NewInlineKeyboardButtonData(toggleLabel(lang.Translate("models.vision", language), s.filter.Vision), "mb:f:vision"),
+	))
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return text, &markup
+}
+
+func (s *browserState) renderDetails(language string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	const maxDescription = 1500
+
+	m := s.shown[s.details]
+	description := m.Description
+	if runes := []rune(description); len(runes) > maxDescription {
+		description = string(runes[:maxDescription-1]) + "…"
+	}
+	modality := m.Architecture.Modality
+	if modality == "" {
+		modality = "text->text"
+	}
+	name := m.Name
+	if name == "" {
+		name = m.ID
+	}
+
+	text := fmt.Sprintf(lang.Translate("models.details", language),
+		html.EscapeString(name), html.EscapeString(m.ID), m.ContextLength,
+		m.PromptPrice(), m.CompletionPrice(), html.EscapeString(modality),
+		html.EscapeString(description))
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(lang.Translate("models.use", language), fmt.Sprintf("mb:u:%d", s.details)),
+		tgbotapi.NewInlineKeyboardButtonData(lang.Translate("models.back", language), "mb:b"),
+	))
+	return text, &markup
+}
+
+func toggleLabel(label string, on bool) string {
+	if on {
+		return "✅ " + label
+	}
+	return label
+}
@@ -2,16 +2,11 @@ package api
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"openrouter-bot/config"
-	configs "openrouter-bot/config"
 	"openrouter-bot/lang"
 	"openrouter-bot/user"
-	"strings"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -19,127 +14,160 @@ import (
 )
 
 type Model struct {
-	ID          string `json:"id"`
-	Description string `json:"description"`
-	Pricing     struct {
-		Prompt string `json:"prompt"`
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	ContextLength int    `json:"context_length"`
+	Pricing       struct {
+		Prompt     string `json:"prompt"`
+		Completion string `json:"completion"`
 	} `json:"pricing"`
+	Architecture struct {
+		Modality        string   `json:"modality"`
+		InputModalities []string `json:"input_modalities"`
+	} `json:"architecture"`
+	SupportedParameters []string `json:"supported_parameters"`
 }
 
 type APIResponse struct {
 	Data []Model `json:"data"`
 }
 
-func GetFreeModels() (string, error) {
-	manager, err := config.NewManager("./config.yaml")
-	if err != nil {
-		log.Fatalf("Error initializing config manager: %v", err)
+// HandleChatGPTStreamResponse answers the message with the model. statusID is
+// the message showing the request status, a new one is sent if it is 0.
+// Cancelling ctx stops the request. The user is charged for the answer.
+func HandleChatGPTStreamResponse(ctx context.Context, bot *tgbotapi.BotAPI, providers *Providers, message *tgbotapi.Message, config *config.Config, user *user.UsageTracker, statusID int) {
+	catalog := providers.catalog
+	user.CheckHistory(config.MaxHistorySize, config.MaxHistoryTime)
+	user.FitContext(config.MaxContextTokens, contextReserve(user, message.Text, config.MaxTokens))
+	user.LastMessageTime = time.Now()
+
+	loadMessage := lang.Translate("loadText", user.Lang(config))
+
+	// A queued request still shows its position in the queue
+	lastMessageID, shown := statusID, ""
+	if lastMessageID == 0 {
+		sentMsg, err := bot.Send(tgbotapi.NewMessage(message.Chat.ID, loadMessage))
+		if err != nil {
+			log.Printf("Failed to send processing message: %v", err)
+			return
+		}
+		lastMessageID, shown = sentMsg.MessageID, loadMessage
 	}
-	conf := manager.GetConfig()
+	status := newRequestStatus(ctx, bot, message.Chat.ID, lastMessageID, user.Lang(config), shown)
+	defer status.done()
+	status.phase("loadText")
 
-	resp, err := http.Get(conf.OpenAIBaseURL + "/models")
-	if err != nil {
-		return "", fmt.Errorf("error get models: %v", err)
+	messages := historyMessages(user)
+
+	model := user.Model(config)
+	role := user.GetUserRole(config)
+	if !catalog.Allowed(config, role, model) {
+		status.phase("commands.modelNotAllowed", model)
+		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("error read response: %v", err)
+	if config.Vision == "true" && catalog.SupportsVision(model) {
+		messages = append(messages, addVisionMessage(bot, message, config))
+	} else {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: message.Text,
+		})
 	}
 
-	var apiResponse APIResponse
-	err = json.Unmarshal(body, &apiResponse)
-	if err != nil {
-		return "", fmt.Errorf("error parse json: %v", err)
+	req := chatRequest(model, messages, config)
+	if est, ok := providers.FitBudget(&req, config, user); !ok {
+		status.phase("estimate.overBudget", est.WorstCost, est.Remaining)
+		return
 	}
 
-	var result strings.Builder
-	for _, model := range apiResponse.Data {
-		if model.Pricing.Prompt == "0" {
-			result.WriteString(fmt.Sprintf("➡ &grave;%s&grave;\n", model.ID))
+	status.phase("status.waiting", req.Model)
+	completion, provider, err := providers.Complete(ctx, req)
+	if err != nil && ctx.Err() == nil && config.FallbackModel != "" && config.FallbackModel != req.Model && catalog.Allowed(config, role, config.FallbackModel) {
+		fallback := req
+		fallback.Model = config.FallbackModel
+		fallback.MaxTokens = config.MaxTokens
+		if _, ok := providers.FitBudget(&fallback, config, user); ok {
+			log.Printf("ChatCompletion error with %s, retrying with %s: %v", req.Model, fallback.Model, err)
+			req = fallback
+			status.phase("status.fallback", req.Model)
+			completion, provider, err = providers.Complete(ctx, req)
 		}
 	}
-	return result.String(), nil
-}
-
-func HandleChatGPTStreamResponse(bot *tgbotapi.BotAPI, client *openai.Client, message *tgbotapi.Message, config *config.Config, user *user.UsageTracker) string {
-	ctx := context.Background()
-	user.CheckHistory(config.MaxHistorySize, config.MaxHistoryTime)
-	user.LastMessageTime = time.Now()
-
-	err := lang.LoadTranslations("./lang/")
-	if err != nil {
-		log.Fatalf("Error loading translations: %v", err)
+	if err != nil && ctx.Err() != nil {
+		status.phase("queue.cancelled")
+		return
 	}
-
-	manager, err := configs.NewManager("./config.yaml")
 	if err != nil {
-		log.Fatalf("Error initializing config manager: %v", err)
+		fmt.Printf("ChatCompletion error: %v\n", err)
+		status.phase("errorText")
+		return
 	}
+	// The provider bills a finished answer even if /stop came in meanwhile
+	if ctx.Err() != nil {
+		providers.Charge(context.Background(), provider, completion, user)
+		status.phase("queue.cancelled")
+		return
+	}
+
+	user.AddMessage(openai.ChatMessageRoleUser, message.Text)
+	user.AddResponse(completion.Text, completion.Model, completion.ID)
 
-	conf := manager.GetConfig()
+	fileMode, fileThreshold := user.FileDelivery(config)
+	sendResponse(bot, message.Chat.ID, completion.Text, lastMessageID, fileMode, fileThreshold, user.Lang(config))
 
-	loadMessage := lang.Translate("loadText", conf.Lang)
-	errorMessage := lang.Translate("errorText", conf.Lang)
+	providers.Charge(context.Background(), provider, completion, user)
+}
 
-	processingMsg := tgbotapi.NewMessage(message.Chat.ID, loadMessage)
-	sentMsg, err := bot.Send(processingMsg)
-	if err != nil {
-		log.Printf("Failed to send processing message: %v", err)
-		return ""
+// chatRequest returns the request of a chat message to the model.
+func chatRequest(model string, messages []openai.ChatCompletionMessage, config *config.Config) openai.ChatCompletionRequest {
+	return openai.ChatCompletionRequest{
+		Model:            model,
+		FrequencyPenalty: float32(config.Model.FrequencyPenalty),
+		PresencePenalty:  float32(config.Model.PresencePenalty),
+		Temperature:      float32(config.Model.Temperature),
+		TopP:             float32(config.Model.TopP),
+		MaxTokens:        config.MaxTokens,
+		Messages:         messages,
 	}
-	lastMessageID := sentMsg.MessageID
+}
+
+// historyMessages returns the system prompt and the pinned messages followed
+// by the user's history.
+func historyMessages(ut *user.UsageTracker) []openai.ChatCompletionMessage {
+	return contextMessages(ut, ut.GetMessages())
+}
 
+// contextMessages returns the system prompt and the pinned messages followed
+// by history.
+func contextMessages(ut *user.UsageTracker, history []user.Message) []openai.ChatCompletionMessage {
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: user.SystemPrompt,
+			Content: ut.SystemPrompt,
 		},
 	}
-
-	for _, msg := range user.GetMessages() {
+	if pinned := user.PinnedContext(ut.Pins()); pinned != "" {
 		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
+			Role:    openai.ChatMessageRoleSystem,
+			Content: pinned,
 		})
 	}
 
-	if config.Vision == "true" {
-		messages = append(messages, addVisionMessage(bot, message, config))
-	} else {
+	for _, msg := range history {
 		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleUser,
-			Content: message.Text,
+			Role:    msg.Role,
+			Content: msg.Content,
 		})
 	}
+	return messages
+}
 
-	req := openai.ChatCompletionRequest{
-		Model:            config.Model.ModelName,
-		FrequencyPenalty: float32(config.Model.FrequencyPenalty),
-		PresencePenalty:  float32(config.Model.PresencePenalty),
-		Temperature:      float32(config.Model.Temperature),
-		TopP:             float32(config.Model.TopP),
-		MaxTokens:        config.MaxTokens,
-		Messages:         messages,
-	}
-
-	resp, err := client.CreateChatCompletion(ctx, req)
-	if err != nil {
-		fmt.Printf("ChatCompletion error: %v\n", err)
-		bot.Send(tgbotapi.NewEditMessageText(message.Chat.ID, lastMessageID, errorMessage))
-		return ""
-	}
-
-	messageText := resp.Choices[0].Message.Content
-	responseID := resp.ID
-
-	user.AddMessage(openai.ChatMessageRoleUser, message.Text)
-	user.AddMessage(openai.ChatMessageRoleAssistant, messageText)
-
-	sendChunkedMessage(bot, message.Chat.ID, messageText, lastMessageID)
-
-	return responseID
+// contextReserve estimates the tokens of the context that are never trimmed:
+// the system prompt, the pinned messages, the new message and the answer.
+func contextReserve(ut *user.UsageTracker, text string, maxTokens int) int {
+	return user.EstimateTokens(ut.SystemPrompt) + user.PinnedTokens(ut.Pins()) + user.EstimateTokens(text) + maxTokens
 }
 
 func sendChunkedMessage(bot *tgbotapi.BotAPI, chatID int64, text string, messageID int) {
@@ -147,62 +175,43 @@ func sendChunkedMessage(bot *tgbotapi.BotAPI, chatID int64, text string, message
 		log.Printf("Warning: sendChunkedMessage called with empty text")
 		return
 	}
-	const chunkSize = 4096
-	if len(text) <= chunkSize {
-		editMsg := tgbotapi.NewEditMessageText(chatID, messageID, text)
-		editMsg.ParseMode = tgbotapi.ModeMarkdown
-		_, err := bot.Send(editMsg)
-		if err != nil {
-			log.Printf("Failed to edit message: %v", err)
-		}
-		return
-	}
-
-	runes := []rune(text)
-	var from, to int
 
-	// First chunk
-	to = chunkSize
-	if to > len(runes) {
-		to = len(runes)
-	}
-	lastNewline := strings.LastIndex(string(runes[from:to]), "\n")
-	if lastNewline != -1 {
-		to = from + lastNewline
+	for i, part := range splitMarkdown(text, telegramMessageLimit) {
+		// The first part replaces the loading message, the rest are sent as new messages
+		if i > 0 {
+			messageID = 0
+		}
+		if err := sendFormatted(bot, chatID, messageID, part); err != nil {
+			log.Printf("Failed to send part %d: %v", i+1, err)
+			if i == 0 {
+				return
+			}
+		}
 	}
+}
 
-	editMsg := tgbotapi.NewEditMessageText(chatID, messageID, string(runes[from:to]))
-	editMsg.ParseMode = tgbotapi.ModeMarkdown
-	_, err := bot.Send(editMsg)
-	if err != nil {
-		log.Printf("Failed to send initial chunk: %v", err)
-		return
+// sendFormatted sends the Markdown text as Telegram HTML, editing messageID
+// when it is set. If Telegram still rejects the markup, the text is resent
+// as plain text so the user never stays stuck on the loading message.
+func sendFormatted(bot *tgbotapi.BotAPI, chatID int64, messageID int, text string) error {
+	_, err := bot.Send(formattedMessage(chatID, messageID, MarkdownToHTML(text), tgbotapi.ModeHTML))
+	if err == nil {
+		return nil
 	}
-	from = to
-
-	// Subsequent chunks
-	for from < len(runes) {
-		to = from + chunkSize
-		if to > len(runes) {
-			to = len(runes)
-		}
-		lastNewline := strings.LastIndex(string(runes[from:to]), "\n")
-		if lastNewline != -1 && from+lastNewline < len(runes) {
-			to = from + lastNewline
-		}
+	log.Printf("Telegram rejected formatted message, retrying as plain text: %v", err)
+	_, err = bot.Send(formattedMessage(chatID, messageID, text, ""))
+	return err
+}
 
-		chunk := string(runes[from:to])
-		if chunk == "" {
-			continue
-		}
-		msg := tgbotapi.NewMessage(chatID, chunk)
-		msg.ParseMode = tgbotapi.ModeMarkdown
-		_, err := bot.Send(msg)
-		if err != nil {
-			log.Printf("Failed to send chunk: %v", err)
-		}
-		from = to
+func formattedMessage(chatID int64, messageID int, text, parseMode string) tgbotapi.Chattable {
+	if messageID != 0 {
+		editMsg := tgbotapi.NewEditMessageText(chatID, messageID, text)
+		editMsg.ParseMode = parseMode
+		return editMsg
 	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = parseMode
+	return msg
 }
 
 func addVisionMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message, config *config.Config) openai.ChatCompletionMessage {
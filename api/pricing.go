@@ -0,0 +1,41 @@
+package api
+
+import (
+	"openrouter-bot/config"
+	"strings"
+)
+
+// pricing computes the cost of a completion from its token usage. The price
+// of a model is taken from model_prices in the config, then from the catalog,
+// and otherwise token_price (USD per 1000 tokens) is charged.
+type pricing struct {
+	prices     map[string]config.ModelPrice
+	tokenPrice float64
+	catalog    *Catalog
+}
+
+func newPricing(conf *config.Config, catalog *Catalog) pricing {
+	return pricing{
+		prices:     conf.ModelPrices,
+		tokenPrice: conf.TokenPrice,
+		catalog:    catalog,
+	}
+}
+
+// cost returns the price of the completion in USD. names are additional
+// model names to look the prices up by, besides the requested and the
+// answering model.
+func (p pricing) cost(c Completion, names ...string) float64 {
+	names = append([]string{c.Requested, c.Model}, names...)
+	for _, name := range names {
+		if price, ok := p.prices[strings.ToLower(name)]; ok {
+			return (float64(c.PromptTokens)*price.Prompt + float64(c.CompletionTokens)*price.Completion) / 1e6
+		}
+	}
+	for _, name := range names {
+		if cost, ok := p.catalog.EstimateCost(name, c.PromptTokens, c.CompletionTokens); ok {
+			return cost
+		}
+	}
+	return float64(c.PromptTokens+c.CompletionTokens) / 1000 * p.tokenPrice
+}
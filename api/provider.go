@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"openrouter-bot/config"
+	"openrouter-bot/user"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Provider types selectable in the config.
+const (
+	ProviderOpenRouter = "openrouter"
+	ProviderOpenAI     = "openai"
+	ProviderAnthropic  = "anthropic"
+)
+
+// Completion is the answer of a provider to a chat request. Requested is the
+// model sent to the provider, Model is the one that answered.
+type Completion struct {
+	ID               string
+	Requested        string
+	Model            string
+	Text             string
+	PromptTokens     int
+	CompletionTokens int
+	// route is the prefix of the provider route, empty for the default provider
+	route string
+}
+
+// Provider is an LLM API backend. Requests are described with the OpenAI chat
+// types, every provider converts them to its own API and reports the cost of
+// its completions in its own way.
+type Provider interface {
+	Complete(ctx context.Context, req openai.ChatCompletionRequest) (Completion, error)
+	// Cost returns the price of the completion in USD.
+	Cost(ctx context.Context, c Completion) (float64, error)
+}
+
+// deferredCostProvider is a provider whose cost is only known some time after
+// the completion. The user is charged the estimate first, and the difference
+// once the reconciler gets the real cost.
+type deferredCostProvider interface {
+	Provider
+	Estimate(c Completion) float64
+}
+
+// Providers routes models to providers by the model name prefix, models
+// without a matching prefix go to the default provider configured with TYPE,
+// BASE_URL and API_KEY.
+type Providers struct {
+	catalog    *Catalog
+	fallback   Provider
+	routes     []providerRoute
+	reconciler *reconciler
+	onCharge   func(ut *user.UsageTracker, day string, cost float64)
+	conf       *config.Config
+}
+
+type providerRoute struct {
+	prefix   string
+	strip    bool
+	provider Provider
+}
+
+// NewProviders sets up the providers. Pending cost reconciliations are kept in
+// logsDir and charged to the users of users.
+func NewProviders(conf *config.Config, catalog *Catalog, logsDir string, users *user.Manager) *Providers {
+	p := &Providers{
+		conf:     conf,
+		catalog:  catalog,
+		fallback: newProvider(conf.Model.Type, conf.OpenAIBaseURL, conf.OpenAIApiKey, newPricing(conf, catalog)),
+	}
+	for _, pc := range conf.Providers {
+		if pc.Prefix == "" {
+			log.Printf("Skipping provider %q without a prefix", pc.Type)
+			continue
+		}
+		p.routes = append(p.routes, providerRoute{
+			prefix:   pc.Prefix,
+			strip:    pc.StripPrefix,
+			provider: newProvider(pc.Type, pc.BaseURL, os.Getenv(pc.APIKeyEnv), newPricing(conf, catalog)),
+		})
+	}
+	p.reconciler = newReconciler(filepath.Join(logsDir, "reconcile.json"), p, users, conf)
+	return p
+}
+
+// Start retries the pending cost reconciliations in the background.
+func (p *Providers) Start() {
+	p.reconciler.start()
+}
+
+// OnCharge sets a function called after every charge of a user with the
+// usage day (YYYY-MM-DD) and the cost charged, including the corrections of
+// the reconciler.
+func (p *Providers) OnCharge(fn func(ut *user.UsageTracker, day string, cost float64)) {
+	p.onCharge = fn
+}
+
+func (p *Providers) charged(ut *user.UsageTracker, day string, cost float64) {
+	if p.onCharge != nil {
+		p.onCharge(ut, day, cost)
+	}
+}
+
+// today returns the current usage day (YYYY-MM-DD).
+func (p *Providers) today() string {
+	return time.Now().In(p.conf.Location()).Format("2006-01-02")
+}
+
+func newProvider(kind, baseURL, apiKey string, prices pricing) Provider {
+	switch strings.ToLower(kind) {
+	case ProviderAnthropic:
+		return newAnthropicProvider(baseURL, apiKey, prices)
+	case ProviderOpenRouter:
+		return newOpenRouterProvider(baseURL, apiKey, prices)
+	default:
+		return newOpenAIProvider(baseURL, apiKey, prices)
+	}
+}
+
+// For returns the provider of the model and the model name to send to it.
+func (p *Providers) For(model string) (Provider, string) {
+	route := p.route(model)
+	if route.strip {
+		model = strings.TrimPrefix(model, route.prefix)
+	}
+	return route.provider, model
+}
+
+func (p *Providers) route(model string) providerRoute {
+	for _, route := range p.routes {
+		if strings.HasPrefix(model, route.prefix) {
+			return route
+		}
+	}
+	return providerRoute{provider: p.fallback}
+}
+
+// byPrefix returns the provider of the route with the prefix.
+func (p *Providers) byPrefix(prefix string) Provider {
+	for _, route := range p.routes {
+		if route.prefix == prefix {
+			return route.provider
+		}
+	}
+	return p.fallback
+}
+
+// Known reports whether the model can be used: models of a routed provider
+// are always accepted, the others must be in the catalog.
+func (p *Providers) Known(model string) bool {
+	for _, route := range p.routes {
+		if strings.HasPrefix(model, route.prefix) {
+			return true
+		}
+	}
+	return p.catalog.Known(model)
+}
+
+// knownPlain reports whether a model ID without a vendor, "gpt-4o" for
+// example, is known. Without a catalog any word would be, so none is.
+func (p *Providers) knownPlain(model string) bool {
+	return p.catalog.Loaded() && p.Known(model)
+}
+
+// Complete sends the request to the provider of req.Model.
+func (p *Providers) Complete(ctx context.Context, req openai.ChatCompletionRequest) (Completion, Provider, error) {
+	route := p.route(req.Model)
+	provider, model := p.For(req.Model)
+	req.Model = model
+	completion, err := provider.Complete(ctx, req)
+	completion.Requested = model
+	completion.route = route.prefix
+	if completion.Model == "" {
+		completion.Model = model
+	}
+	return completion, provider, err
+}
+
+// Charge adds the cost of the completion to the user's usage. For providers
+// with a deferred cost this is an estimate, corrected later by the reconciler.
+func (p *Providers) Charge(ctx context.Context, provider Provider, c Completion, ut *user.UsageTracker) (float64, error) {
+	tokens := c.PromptTokens + c.CompletionTokens
+	if deferred, ok := provider.(deferredCostProvider); ok {
+		cost := deferred.Estimate(c)
+		ut.Charge(c.ID, cost, tokens)
+		p.reconciler.add(c, ut, cost)
+		p.charged(ut, p.today(), cost)
+		return cost, nil
+	}
+
+	cost, err := provider.Cost(ctx, c)
+	if err != nil {
+		log.Printf("Error getting cost of %s for user %s: %v", c.ID, ut.UserID, err)
+		return 0, err
+	}
+	fmt.Printf("Total Cost for user %s: %.6f\n", ut.UserID, cost)
+	ut.Charge(c.ID, cost, tokens)
+	p.charged(ut, p.today(), cost)
+	return cost, nil
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	anthropicBaseURL = "https://api.anthropic.com/v1"
+	anthropicVersion = "2023-06-01"
+	// anthropicDefaultMaxTokens is sent when the request has no max_tokens,
+	// the Messages API requires one.
+	anthropicDefaultMaxTokens = 4096
+	// anthropicTimeout limits a request, the answer is not streamed so a long
+	// one takes a while, but a hung request must not block the user's queue.
+	anthropicTimeout = 5 * time.Minute
+)
+
+// anthropicProvider talks to the Anthropic Messages API. The cost is computed
+// locally from the token usage, the catalog prices are those of "anthropic/<model>".
+type anthropicProvider struct {
+	baseURL string
+	apiKey  string
+	prices  pricing
+	http    *http.Client
+}
+
+func newAnthropicProvider(baseURL, apiKey string, prices pricing) *anthropicProvider {
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	return &anthropicProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		prices:  prices,
+		http:    &http.Client{Timeout: anthropicTimeout},
+	}
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Temperature float32            `json:"temperature,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicContent struct {
+	Type   string           `json:"type"`
+	Text   string           `json:"text,omitempty"`
+	Source *anthropicSource `json:"source,omitempty"`
+}
+
+type anthropicSource struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type anthropicResponse struct {
+	ID      string             `json:"id"`
+	Model   string             `json:"model"`
+	Content []anthropicContent `json:"content"`
+	Usage   struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, req openai.ChatCompletionRequest) (Completion, error) {
+	body, err := json.Marshal(anthropicRequestFrom(req))
+	if err != nil {
+		return Completion{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return Completion{}, fmt.Errorf("error creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := p.http.Do(httpReq)
+	if err != nil {
+		return Completion{}, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Completion{}, fmt.Errorf("error read response: %w", err)
+	}
+	var result anthropicResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return Completion{}, fmt.Errorf("error parse json: %w", err)
+	}
+	if result.Error != nil {
+		return Completion{}, fmt.Errorf("%s: %s", result.Error.Type, result.Error.Message)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Completion{}, fmt.Errorf("status %s", resp.Status)
+	}
+
+	var text strings.Builder
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return Completion{}, fmt.Errorf("empty response")
+	}
+	return Completion{
+		ID:               result.ID,
+		Model:            result.Model,
+		Text:             text.String(),
+		PromptTokens:     result.Usage.InputTokens,
+		CompletionTokens: result.Usage.OutputTokens,
+	}, nil
+}
+
+func (p *anthropicProvider) Cost(ctx context.Context, c Completion) (float64, error) {
+	return p.prices.cost(c, "anthropic/"+c.Requested), nil
+}
+
+// anthropicRequestFrom converts an OpenAI chat request: system messages go to
+// the system prompt, image URLs become URL image sources.
+func anthropicRequestFrom(req openai.ChatCompletionRequest) anthropicRequest {
+	result := anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+	}
+	if result.MaxTokens <= 0 {
+		result.MaxTokens = anthropicDefaultMaxTokens
+	}
+	var system []string
+	for _, msg := range req.Messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			if msg.Content != "" {
+				system = append(system, msg.Content)
+			}
+			continue
+		}
+
+		converted := anthropicMessage{Role: msg.Role}
+		if msg.Content != "" {
+			converted.Content = append(converted.Content, anthropicContent{Type: "text", Text: msg.Content})
+		}
+		for _, part := range msg.MultiContent {
+			switch {
+			case part.Type == openai.ChatMessagePartTypeText && part.Text != "":
+				converted.Content = append(converted.Content, anthropicContent{Type: "text", Text: part.Text})
+			case part.Type == openai.ChatMessagePartTypeImageURL && part.ImageURL != nil:
+				converted.Content = append(converted.Content, anthropicContent{
+					Type:   "image",
+					Source: &anthropicSource{Type: "url", URL: part.ImageURL.URL},
+				})
+			}
+		}
+		if len(converted.Content) > 0 {
+			result.Messages = append(result.Messages, converted)
+		}
+	}
+	result.System = strings.Join(system, "\n\n")
+	return result
+}
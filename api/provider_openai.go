@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"openrouter-bot/user"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// openAIProvider talks to an OpenAI-compatible API: OpenAI itself, LM Studio,
+// Ollama, vLLM. The cost is computed locally from the token usage.
+type openAIProvider struct {
+	client *openai.Client
+	prices pricing
+}
+
+func newOpenAIProvider(baseURL, apiKey string, prices pricing) *openAIProvider {
+	clientOptions := openai.DefaultConfig(apiKey)
+	clientOptions.BaseURL = baseURL
+	return &openAIProvider{
+		client: openai.NewClientWithConfig(clientOptions),
+		prices: prices,
+	}
+}
+
+func (p *openAIProvider) Complete(ctx context.Context, req openai.ChatCompletionRequest) (Completion, error) {
+	resp, err := p.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return Completion{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Completion{}, fmt.Errorf("empty response")
+	}
+	return Completion{
+		ID:               resp.ID,
+		Model:            resp.Model,
+		Text:             resp.Choices[0].Message.Content,
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	}, nil
+}
+
+func (p *openAIProvider) Cost(ctx context.Context, c Completion) (float64, error) {
+	return p.prices.cost(c), nil
+}
+
+// openRouterProvider is the OpenAI-compatible OpenRouter API, the cost of a
+// completion is taken from its generation stats. They are usually ready a few
+// seconds after the completion, so the cost is reconciled in the background.
+type openRouterProvider struct {
+	*openAIProvider
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func newOpenRouterProvider(baseURL, apiKey string, prices pricing) *openRouterProvider {
+	return &openRouterProvider{
+		openAIProvider: newOpenAIProvider(baseURL, apiKey, prices),
+		baseURL:        baseURL,
+		apiKey:         apiKey,
+		http:           &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Estimate computes the cost from the token usage until the generation stats are ready.
+func (p *openRouterProvider) Estimate(c Completion) float64 {
+	return p.prices.cost(c)
+}
+
+func (p *openRouterProvider) Cost(ctx context.Context, c Completion) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+"/generation?id="+url.QueryEscape(c.ID), nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+p.apiKey)
+
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("generation stats: status %s", resp.Status)
+	}
+
+	var generationResponse user.GenerationResponse
+	err = json.NewDecoder(resp.Body).Decode(&generationResponse)
+	if err != nil {
+		return 0, fmt.Errorf("error decoding response: %w", err)
+	}
+	return generationResponse.Data.TotalCost, nil
+}
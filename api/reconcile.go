@@ -0,0 +1,246 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"openrouter-bot/config"
+	"openrouter-bot/user"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// reconcileInterval is how often due reconciliations are retried.
+	reconcileInterval = 5 * time.Second
+	// reconcileFirstDelay gives the provider time to finalize the generation.
+	reconcileFirstDelay = 3 * time.Second
+	reconcileMaxBackoff = time.Hour
+	// reconcileTimeout limits one attempt to fetch a cost.
+	reconcileTimeout = 30 * time.Second
+	// reconcileGiveUp is the age after which a generation is no longer
+	// retried and the estimate stays charged.
+	reconcileGiveUp = 24 * time.Hour
+	// reconcileRetention is how long failed generations are kept for admins.
+	reconcileRetention = 7 * 24 * time.Hour
+	// maxPendingCosts caps the queue, the oldest entries are dropped first.
+	maxPendingCosts = 1000
+)
+
+// PendingCost is a generation charged with an estimate whose real cost is
+// not known yet.
+type PendingCost struct {
+	GenerationID string    `json:"generation_id"`
+	Route        string    `json:"route,omitempty"`
+	UserID       int64     `json:"user_id"`
+	UserName     string    `json:"user_name"`
+	Model        string    `json:"model"`
+	Provisional  float64   `json:"provisional"`
+	Day          string    `json:"day"`
+	Created      time.Time `json:"created"`
+	Attempts     int       `json:"attempts"`
+	NextAttempt  time.Time `json:"next_attempt"`
+	LastError    string    `json:"last_error,omitempty"`
+	Failed       bool      `json:"failed,omitempty"`
+}
+
+// reconciler fetches the real cost of generations charged with an estimate
+// and charges the difference. Pending generations are kept in a JSON file,
+// written with every run, so that they are not lost on restart.
+type reconciler struct {
+	file      string
+	providers *Providers
+	users     *user.Manager
+	conf      *config.Config
+	mu        sync.Mutex
+	pending   []*PendingCost
+	dirty     bool
+	fileMu    sync.Mutex
+}
+
+func newReconciler(file string, providers *Providers, users *user.Manager, conf *config.Config) *reconciler {
+	r := &reconciler{
+		file:      file,
+		providers: providers,
+		users:     users,
+		conf:      conf,
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading pending costs: %v", err)
+		}
+		return r
+	}
+	if err := json.Unmarshal(data, &r.pending); err != nil {
+		log.Printf("Error unmarshalling pending costs: %v", err)
+	}
+	return r
+}
+
+func (r *reconciler) start() {
+	go func() {
+		for {
+			time.Sleep(reconcileInterval)
+			r.run(time.Now())
+		}
+	}()
+}
+
+// add queues the generation charged with the provisional cost.
+func (r *reconciler) add(c Completion, ut *user.UsageTracker, provisional float64) {
+	if c.ID == "" {
+		return
+	}
+	userID, err := strconv.ParseInt(ut.UserID, 10, 64)
+	if err != nil {
+		log.Printf("Invalid user ID %s: %v", ut.UserID, err)
+		return
+	}
+	now := time.Now()
+
+	// Saved at once, a restart before the next run must not lose the entry
+	defer r.save()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, &PendingCost{
+		GenerationID: c.ID,
+		Route:        c.route,
+		UserID:       userID,
+		UserName:     ut.UserName,
+		Model:        c.Model,
+		Provisional:  provisional,
+		Day:          now.In(r.conf.Location()).Format("2006-01-02"),
+		Created:      now,
+		NextAttempt:  now.Add(reconcileFirstDelay),
+	})
+	if len(r.pending) > maxPendingCosts {
+		log.Printf("Too many pending costs, dropping %s", r.pending[0].GenerationID)
+		r.pending = r.pending[1:]
+	}
+	r.dirty = true
+}
+
+// run fetches the cost of the due generations and saves the changes.
+func (r *reconciler) run(now time.Time) {
+	defer r.save()
+
+	r.mu.Lock()
+	r.prune(func(p *PendingCost) bool {
+		return p.Failed && now.Sub(p.Created) > reconcileRetention
+	})
+	var due []PendingCost
+	for _, p := range r.pending {
+		if !p.Failed && !p.NextAttempt.After(now) {
+			due = append(due, *p)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, p := range due {
+		provider := r.providers.byPrefix(p.Route)
+		ctx, cancel := context.WithTimeout(context.Background(), reconcileTimeout)
+		cost, err := provider.Cost(ctx, Completion{ID: p.GenerationID, Model: p.Model, route: p.Route})
+		cancel()
+
+		if err != nil {
+			r.retry(p.GenerationID, err, now)
+			continue
+		}
+		ut := r.users.GetUser(p.UserID, p.UserName, r.conf)
+		ut.CorrectCharge(p.GenerationID, p.Day, cost-p.Provisional)
+		r.providers.charged(ut, p.Day, cost-p.Provisional)
+		log.Printf("Reconciled cost of %s for user %d: $%.6f (estimated $%.6f)", p.GenerationID, p.UserID, cost, p.Provisional)
+		r.remove(p.GenerationID)
+	}
+}
+
+// retry schedules the next attempt with exponential backoff, or gives up on
+// generations older than reconcileGiveUp.
+func (r *reconciler) retry(generationID string, err error, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.pending {
+		if p.GenerationID != generationID {
+			continue
+		}
+		p.Attempts++
+		p.LastError = err.Error()
+		backoff := reconcileMaxBackoff
+		if p.Attempts < 20 {
+			backoff = min(reconcileInterval<<p.Attempts, reconcileMaxBackoff)
+		}
+		p.NextAttempt = now.Add(backoff)
+		if now.Sub(p.Created) > reconcileGiveUp {
+			p.Failed = true
+			log.Printf("Giving up reconciling the cost of %s after %d attempts: %v", generationID, p.Attempts, err)
+		}
+	}
+	r.dirty = true
+}
+
+func (r *reconciler) remove(generationID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(func(p *PendingCost) bool {
+		return p.GenerationID == generationID
+	})
+}
+
+// prune removes the generations drop returns true for. r.mu must be held.
+func (r *reconciler) prune(drop func(p *PendingCost) bool) {
+	pending := r.pending[:0]
+	for _, p := range r.pending {
+		if !drop(p) {
+			pending = append(pending, p)
+		}
+	}
+	if len(pending) != len(r.pending) {
+		clear(r.pending[len(pending):])
+		r.pending = pending
+		r.dirty = true
+	}
+}
+
+// save writes the pending generations to disk if they changed. They are
+// marshalled under r.mu and written outside of it.
+func (r *reconciler) save() {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return
+	}
+	r.dirty = false
+	data, err := json.MarshalIndent(r.pending, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		log.Printf("Error marshalling pending costs: %v", err)
+		return
+	}
+
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+	if err := os.WriteFile(r.file, data, 0644); err != nil {
+		log.Printf("Error writing pending costs: %v", err)
+	}
+}
+
+// Unreconciled returns up to limit generations still charged with an
+// estimate, the oldest first, and how many there are. Failed generations are
+// kept for reconcileRetention.
+func (p *Providers) Unreconciled(limit int) ([]PendingCost, int) {
+	r := p.reconciler
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]PendingCost, 0, len(r.pending))
+	for _, pending := range r.pending {
+		result = append(result, *pending)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result[:min(len(result), limit)], len(result)
+}
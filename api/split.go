@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// Telegram limits a message to 4096 UTF-16 code units after entity parsing.
+const (
+	telegramMessageLimit = 4096
+	partNumberReserve    = 16
+)
+
+var htmlTagRe = regexp.MustCompile(`<[^>]*>`)
+
+// splitMarkdown splits a Markdown text into parts that fit into one Telegram
+// message each. Parts are cut on line boundaries and never inside a fenced
+// code block: an open fence is closed at the end of a part and reopened with
+// the same language at the start of the next one. When there is more than
+// one part, each of them is numbered like "(2/3)".
+func splitMarkdown(text string, limit int) []string {
+	if messageLength(text) <= limit {
+		return []string{text}
+	}
+	limit -= partNumberReserve
+
+	var parts []string
+	var current []string
+	var fence, fenceHeader string
+
+	// closeCurrent ends the current part, an open code block is closed and
+	// reopened in the next part if reopen is set.
+	closeCurrent := func(reopen bool) {
+		if len(current) == 0 {
+			return
+		}
+		part := strings.Join(current, "\n")
+		if fence != "" {
+			part += "\n" + fence
+		}
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, strings.Trim(part, "\n"))
+		}
+		current = nil
+		if fence != "" && reopen {
+			current = append(current, fenceHeader)
+		}
+	}
+
+	fits := func(lines []string) bool {
+		part := strings.Join(lines, "\n")
+		if fence != "" {
+			part += "\n" + fence
+		}
+		return messageLength(part) <= limit
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if fence != "" && isClosingFence(line, fence) && !fits(append(current, line)) {
+			// The part is closed with the fence anyway, the next one must not
+			// start with an empty block
+			closeCurrent(false)
+			fence, fenceHeader = "", ""
+			continue
+		}
+		for _, piece := range splitLongLine(line, limit/2) {
+			if !fits(append(current, piece)) {
+				closeCurrent(true)
+			}
+			current = append(current, piece)
+		}
+
+		if m := fenceRe.FindStringSubmatch(line); m != nil && fence == "" {
+			fence, fenceHeader = m[1], strings.TrimSpace(line)
+		} else if fence != "" && isClosingFence(line, fence) {
+			fence, fenceHeader = "", ""
+		}
+	}
+	fence = ""
+	closeCurrent(false)
+
+	if len(parts) > 1 {
+		for i := range parts {
+			parts[i] += fmt.Sprintf("\n\n(%d/%d)", i+1, len(parts))
+		}
+	}
+	return parts
+}
+
+// splitLongLine cuts a single line that cannot fit into a message on its
+// own, preferring spaces as cut points.
+func splitLongLine(line string, limit int) []string {
+	runes := []rune(line)
+	var pieces []string
+	for len(runes) > limit {
+		cut := limit
+		if space := strings.LastIndex(string(runes[:limit]), " "); space > 0 {
+			cut = len([]rune(string(runes[:limit])[:space]))
+		}
+		pieces = append(pieces, string(runes[:cut]))
+		runes = runes[cut:]
+	}
+	return append(pieces, string(runes))
+}
+
+// messageLength returns how long the Markdown text is for Telegram: the
+// visible length once converted to HTML entities, or the raw length if it
+// has to be resent as plain text, whichever is larger.
+func messageLength(text string) int {
+	visible := html.UnescapeString(htmlTagRe.ReplaceAllString(MarkdownToHTML(text), ""))
+	return max(utf16Length(visible), utf16Length(text))
+}
+
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSplitMarkdown(t *testing.T) {
+	code := make([]string, 40)
+	for i := range code {
+		code[i] = fmt.Sprintf("line %02d := value", i)
+	}
+
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		// parts is the expected number of parts
+		parts int
+	}{
+		{"short text is not split", "hello *world*", 100, 1},
+		{"exact limit is not split", strings.Repeat("a", 100), 100, 1},
+		{"lines", strings.Repeat("some words here\n", 20), 100, 4},
+		{"long line", strings.Repeat("word ", 100), 100, 7},
+		{"long line without spaces", strings.Repeat("x", 300), 100, 7},
+		{"code block", "intro\n```go\n" + strings.Join(code, "\n") + "\n```\noutro", 200, 5},
+		// Emoji take two UTF-16 code units each
+		{"utf-16 length", strings.Repeat("😀😀😀😀😀\n", 20), 100, 3},
+		{"cyrillic", strings.Repeat("привет мир\n", 30), 100, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitMarkdown(tt.text, tt.limit)
+			if len(parts) != tt.parts {
+				t.Errorf("got %d parts, want %d: %q", len(parts), tt.parts, parts)
+			}
+			for i, part := range parts {
+				if n := messageLength(part); n > tt.limit {
+					t.Errorf("part %d is %d long, limit %d: %q", i+1, n, tt.limit, part)
+				}
+				if len(parts) > 1 && !strings.HasSuffix(part, fmt.Sprintf("\n\n(%d/%d)", i+1, len(parts))) {
+					t.Errorf("part %d is not numbered: %q", i+1, part)
+				}
+				if fences := strings.Count(part, "```"); fences%2 != 0 {
+					t.Errorf("part %d has an unclosed code block: %q", i+1, part)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitMarkdownReopensFence(t *testing.T) {
+	code := make([]string, 30)
+	for i := range code {
+		code[i] = fmt.Sprintf("print(%d)", i)
+	}
+	text := "```python\n" + strings.Join(code, "\n") + "\n```"
+
+	parts := splitMarkdown(text, 150)
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts, want several", len(parts))
+	}
+	var lines []string
+	for i, part := range parts {
+		body := strings.TrimSuffix(part, fmt.Sprintf("\n\n(%d/%d)", i+1, len(parts)))
+		if !strings.HasPrefix(body, "```python\n") || !strings.HasSuffix(body, "\n```") {
+			t.Errorf("part %d is not a complete python block: %q", i+1, part)
+		}
+		body = strings.TrimSuffix(strings.TrimPrefix(body, "```python\n"), "\n```")
+		lines = append(lines, strings.Split(body, "\n")...)
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(code, "\n") {
+		t.Errorf("code changed by the split:\n%s", got)
+	}
+}
+
+func TestSplitLongLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		limit int
+		want  []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"aaaa bbbb cccc", 10, []string{"aaaa bbbb", " cccc"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"ёёёё ёёёё", 6, []string{"ёёёё", " ёёёё"}},
+	}
+	for _, tt := range tests {
+		got := splitLongLine(tt.line, tt.limit)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("splitLongLine(%q, %d) = %q, want %q", tt.line, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestMessageLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"plain", 5},
+		{"**bold**", 8},
+		{"a & b", 5},
+		{"😀", 2},
+		{"привет", 6},
+	}
+	for _, tt := range tests {
+		if got := messageLength(tt.text); got != tt.want {
+			t.Errorf("messageLength(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitMarkdownClosingFenceAtPartStart(t *testing.T) {
+	// The closing fence of this block is the first line that does not fit
+	code := make([]string, 18)
+	for i := range code {
+		code[i] = fmt.Sprintf("x := %02d", i)
+	}
+	text := "```go\n" + strings.Join(code, "\n") + "\n```\nafter"
+
+	parts := splitMarkdown(text, 100)
+	for i, part := range parts {
+		if strings.Contains(part, "```go\n```") {
+			t.Errorf("part %d has an empty code block: %q", i+1, part)
+		}
+		if fences := strings.Count(part, "```"); fences%2 != 0 {
+			t.Errorf("part %d has an unclosed code block: %q", i+1, part)
+		}
+	}
+	if last := parts[len(parts)-1]; !strings.HasPrefix(last, "after") {
+		t.Errorf("last part = %q, want the text after the block", last)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"openrouter-bot/lang"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram shows a chat action for about five seconds, so it is repeated
+// a bit more often than that.
+const typingInterval = 4 * time.Second
+
+// requestStatus shows the progress of a request: the "typing" chat action and
+// the current phase in the placeholder message.
+type requestStatus struct {
+	bot       *tgbotapi.BotAPI
+	chatID    int64
+	messageID int
+	lang      string
+	mu        sync.Mutex
+	last      string
+	stop      context.CancelFunc
+}
+
+// newRequestStatus starts sending the "typing" action until done is called.
+// shown is the text the status message currently has.
+func newRequestStatus(ctx context.Context, bot *tgbotapi.BotAPI, chatID int64, messageID int, language, shown string) *requestStatus {
+	ctx, stop := context.WithCancel(ctx)
+	s := &requestStatus{
+		bot:       bot,
+		chatID:    chatID,
+		messageID: messageID,
+		lang:      language,
+		last:      shown,
+		stop:      stop,
+	}
+
+	go func() {
+		ticker := time.NewTicker(typingInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := bot.Request(tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping)); err != nil {
+				log.Printf("Failed to send chat action: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// phase shows the translated status in the placeholder message, string
+// arguments are escaped. The message is only edited when the text changes,
+// Telegram rejects identical edits.
+func (s *requestStatus) phase(key string, args ...interface{}) {
+	text := lang.Translate(key, s.lang)
+	if len(args) > 0 {
+		for i, arg := range args {
+			if str, ok := arg.(string); ok {
+				args[i] = html.EscapeString(str)
+			}
+		}
+		text = fmt.Sprintf(text, args...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if text == s.last {
+		return
+	}
+	s.last = text
+	msg := tgbotapi.NewEditMessageText(s.chatID, s.messageID, text)
+	msg.ParseMode = "HTML"
+	if _, err := s.bot.Send(msg); err != nil {
+		log.Printf("Failed to update request status: %v", err)
+	}
+}
+
+// done stops the "typing" action.
+func (s *requestStatus) done() {
+	s.stop()
+}
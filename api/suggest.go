@@ -0,0 +1,65 @@
+package api
+
+import (
+	"sort"
+	"strings"
+)
+
+// Suggest returns up to n model IDs closest to the given one by edit
+// distance, for "did you mean" hints. The name is also compared with the part
+// of the IDs after the provider, so "gpt-4o-mni" finds "openai/gpt-4o-mini".
+func (c *Catalog) Suggest(id string, n int) []string {
+	id = strings.ToLower(id)
+	// Allow about one typo per three characters
+	maxDistance := len([]rune(id))/3 + 1
+
+	type candidate struct {
+		id       string
+		distance int
+	}
+	var candidates []candidate
+
+	c.mu.RLock()
+	for _, m := range c.models {
+		name := strings.ToLower(m.ID)
+		distance := levenshtein(id, name)
+		if _, short, ok := strings.Cut(name, "/"); ok {
+			distance = min(distance, levenshtein(id, short))
+		}
+		if distance <= maxDistance {
+			candidates = append(candidates, candidate{m.ID, distance})
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+	var result []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		result = append(result, candidates[i].id)
+	}
+	return result
+}
+
+// levenshtein returns the number of single character edits turning a into b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
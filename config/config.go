@@ -13,6 +13,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+	_ "time/tzdata" // TIMEZONE works in images without tzdata
 )
 
 type Config struct {
@@ -35,6 +37,45 @@ type Config struct {
 	VisionDetails      string
 	StatsMinRole       string
 	Lang               string
+	InlineModel        string
+	InlineMaxTokens    int
+	InlineDebounce     int
+	FileMode           string
+	FileThreshold      int
+	MaxContextTokens   int
+	MaxQueueDepth      int
+	FallbackModel      string
+	ModelsCacheTTL     int
+	ModelAliases       map[string]string
+	ModelPolicies      map[string]ModelPolicy
+	Providers          []ProviderConfig
+	ModelPrices        map[string]ModelPrice
+	TokenPrice         float64
+	BudgetThresholds   []int64
+	GlobalBudget       float64
+	MonthlyResetDay    int
+	Timezone           string
+	// location is the loaded Timezone
+	location *time.Location
+}
+
+// ProviderConfig routes models whose name starts with Prefix to another API.
+// Type is openrouter, openai (any OpenAI-compatible API) or anthropic, the
+// API key is read from the environment variable APIKeyEnv.
+type ProviderConfig struct {
+	Prefix      string `mapstructure:"prefix"`
+	Type        string `mapstructure:"type"`
+	BaseURL     string `mapstructure:"base_url"`
+	APIKeyEnv   string `mapstructure:"api_key_env"`
+	StripPrefix bool   `mapstructure:"strip_prefix"`
+}
+
+// ModelPrice is the price of a model in USD per million tokens, used to
+// compute the cost of providers that do not report it.
+type ModelPrice struct {
+	Model      string  `mapstructure:"model"`
+	Prompt     float64 `mapstructure:"prompt"`
+	Completion float64 `mapstructure:"completion"`
 }
 
 type ModelParameters struct {
@@ -67,6 +108,15 @@ func Load() (*Config, error) {
 	viper.SetDefault("MAX_HISTORY_SIZE", 10)
 	viper.SetDefault("MAX_HISTORY_TIME", 60)
 	viper.SetDefault("LANG", "en")
+	viper.SetDefault("INLINE_MAX_TOKENS", 500)
+	viper.SetDefault("INLINE_DEBOUNCE", 800)
+	viper.SetDefault("FILE_MODE", "off")
+	viper.SetDefault("FILE_THRESHOLD", 8000)
+	viper.SetDefault("MAX_CONTEXT_TOKENS", 32000)
+	viper.SetDefault("MAX_QUEUE_DEPTH", 5)
+	viper.SetDefault("MODELS_CACHE_TTL", 60)
+	viper.SetDefault("BUDGET_THRESHOLDS", "50,80,100")
+	viper.SetDefault("MONTHLY_RESET_DAY", 1)
 
 	config := &Config{
 		TelegramBotToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
@@ -93,16 +143,90 @@ func Load() (*Config, error) {
 		VisionDetails:      viper.GetString("VISION_DETAIL"),
 		StatsMinRole:       viper.GetString("STATS_MIN_ROLE"),
 		Lang:               viper.GetString("LANG"),
+		InlineModel:        viper.GetString("INLINE_MODEL"),
+		InlineMaxTokens:    viper.GetInt("INLINE_MAX_TOKENS"),
+		InlineDebounce:     viper.GetInt("INLINE_DEBOUNCE"),
+		FileMode:           viper.GetString("FILE_MODE"),
+		FileThreshold:      viper.GetInt("FILE_THRESHOLD"),
+		MaxContextTokens:   viper.GetInt("MAX_CONTEXT_TOKENS"),
+		MaxQueueDepth:      viper.GetInt("MAX_QUEUE_DEPTH"),
+		FallbackModel:      viper.GetString("FALLBACK_MODEL"),
+		ModelsCacheTTL:     viper.GetInt("MODELS_CACHE_TTL"),
+		ModelAliases:       viper.GetStringMapString("MODEL_ALIASES"),
+		ModelPolicies:      loadModelPolicies(),
+		Providers:          loadProviders(),
+		ModelPrices:        loadModelPrices(),
+		TokenPrice:         viper.GetFloat64("TOKEN_PRICE"),
+		BudgetThresholds:   getStrAsIntList("BUDGET_THRESHOLDS"),
+		GlobalBudget:       viper.GetFloat64("GLOBAL_BUDGET"),
+		MonthlyResetDay:    viper.GetInt("MONTHLY_RESET_DAY"),
+		Timezone:           viper.GetString("TIMEZONE"),
+	}
+	if config.InlineModel == "" {
+		config.InlineModel = config.Model.ModelName
 	}
 	if config.BudgetPeriod == "" {
 		log.Fatalf("Set budget_period in config file")
 	}
+	if !ValidPeriod(config.BudgetPeriod) {
+		log.Fatalf("Invalid budget_period %q, use daily, weekly, monthly, total or a number of days such as 7d", config.BudgetPeriod)
+	}
+	if config.MonthlyResetDay < 1 || config.MonthlyResetDay > 28 {
+		log.Fatalf("Invalid monthly_reset_day %d, use a day from 1 to 28", config.MonthlyResetDay)
+	}
+	if config.Timezone != "" {
+		location, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			log.Fatalf("Invalid timezone %q: %v", config.Timezone, err)
+		}
+		config.location = location
+	}
 	language := lang.Translate("language", config.Lang)
 	config.SystemPrompt = "Always answer in " + language + " language." + config.SystemPrompt
 	printConfig(config)
 	return config, nil
 }
 
+// Location returns the timezone of the usage days and the budget periods, the
+// server timezone if none is configured.
+func (c *Config) Location() *time.Location {
+	if c.location == nil {
+		return time.Local
+	}
+	return c.location
+}
+
+func loadProviders() []ProviderConfig {
+	var providers []ProviderConfig
+	if err := viper.UnmarshalKey("PROVIDERS", &providers); err != nil {
+		log.Printf("Error reading providers: %v", err)
+	}
+	return providers
+}
+
+// loadModelPrices reads model_prices from the config, keyed by the model in
+// lower case. It is a list rather than a map as model names contain dots.
+func loadModelPrices() map[string]ModelPrice {
+	var list []ModelPrice
+	if err := viper.UnmarshalKey("MODEL_PRICES", &list); err != nil {
+		log.Printf("Error reading model_prices: %v", err)
+	}
+	prices := make(map[string]ModelPrice, len(list))
+	for _, price := range list {
+		prices[strings.ToLower(price.Model)] = price
+	}
+	return prices
+}
+
+// ResolveModel returns the model an alias from model_aliases stands for, or
+// the name itself if it is not an alias.
+func (c *Config) ResolveModel(name string) string {
+	if model, ok := c.ModelAliases[strings.ToLower(name)]; ok {
+		return model
+	}
+	return name
+}
+
 func getStrAsIntList(name string) []int64 {
 	valueStr := viper.GetString(name)
 	if valueStr == "" {
@@ -133,6 +257,9 @@ func printConfig(c *Config) {
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
 		fieldName := t.Field(i).Name
+		if !t.Field(i).IsExported() {
+			continue
+		}
 
 		if field.Kind() == reflect.Struct {
 			fmt.Printf("%s:\n", fieldName)
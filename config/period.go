@@ -0,0 +1,78 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Budget periods. A rolling window of the last N days, today included, is
+// written as "Nd", "7d" for example.
+const (
+	PeriodDaily   = "daily"
+	PeriodWeekly  = "weekly"
+	PeriodMonthly = "monthly"
+	PeriodTotal   = "total"
+)
+
+// maxRollingDays limits rolling windows to about a year.
+const maxRollingDays = 366
+
+// ValidPeriod reports whether period is a known budget period.
+func ValidPeriod(period string) bool {
+	switch period {
+	case PeriodDaily, PeriodWeekly, PeriodMonthly, PeriodTotal:
+		return true
+	}
+	_, ok := RollingDays(period)
+	return ok
+}
+
+// RollingDays returns the length of a rolling window period such as "7d",
+// false for the other periods.
+func RollingDays(period string) (int, bool) {
+	days, err := strconv.Atoi(strings.TrimSuffix(period, "d"))
+	if err != nil || !strings.HasSuffix(period, "d") || days < 1 || days > maxRollingDays {
+		return 0, false
+	}
+	return days, true
+}
+
+// PeriodBounds returns the first day of the budget period containing now and
+// the day it resets, both at midnight of the configured timezone. Weeks start
+// on Monday and months on monthly_reset_day. Rolling windows reset one day at
+// a time. Both are zero for the total period.
+func (c *Config) PeriodBounds(period string, now time.Time) (time.Time, time.Time) {
+	now = now.In(c.Location())
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+
+	switch period {
+	case PeriodDaily:
+		return today, today.AddDate(0, 0, 1)
+	case PeriodWeekly:
+		// Monday is day 0 of the week
+		start := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case PeriodMonthly:
+		resetDay := max(c.MonthlyResetDay, 1)
+		start := time.Date(year, month, resetDay, 0, 0, 0, 0, now.Location())
+		if day < resetDay {
+			start = start.AddDate(0, -1, 0)
+		}
+		return start, start.AddDate(0, 1, 0)
+	}
+	if days, ok := RollingDays(period); ok {
+		return today.AddDate(0, 0, 1-days), today.AddDate(0, 0, 1)
+	}
+	return time.Time{}, time.Time{}
+}
+
+// InPeriod reports whether day (YYYY-MM-DD) is in the budget period containing now.
+func (c *Config) InPeriod(day, period string, now time.Time) bool {
+	start, end := c.PeriodBounds(period, now)
+	if start.IsZero() {
+		return true
+	}
+	return day >= start.Format("2006-01-02") && day < end.Format("2006-01-02")
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidPeriod(t *testing.T) {
+	tests := []struct {
+		period string
+		want   bool
+	}{
+		{PeriodDaily, true},
+		{PeriodWeekly, true},
+		{PeriodMonthly, true},
+		{PeriodTotal, true},
+		{"1d", true},
+		{"7d", true},
+		{"366d", true},
+		{"0d", false},
+		{"367d", false},
+		{"-1d", false},
+		{"7", false},
+		{"d", false},
+		{"7dd", false},
+		{"yearly", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidPeriod(tt.period); got != tt.want {
+			t.Errorf("ValidPeriod(%q) = %v, want %v", tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestPeriodBounds(t *testing.T) {
+	utc := &Config{location: time.UTC}
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	// 2026-03-04 is a Wednesday
+	now := time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		conf       *Config
+		period     string
+		now        time.Time
+		start, end time.Time
+	}{
+		{"daily", utc, PeriodDaily, now, day(2026, 3, 4), day(2026, 3, 5)},
+		{"weekly from monday", utc, PeriodWeekly, now, day(2026, 3, 2), day(2026, 3, 9)},
+		{"weekly on monday", utc, PeriodWeekly, day(2026, 3, 2), day(2026, 3, 2), day(2026, 3, 9)},
+		{"weekly on sunday", utc, PeriodWeekly, day(2026, 3, 8), day(2026, 3, 2), day(2026, 3, 9)},
+		{"weekly across months", utc, PeriodWeekly, day(2026, 4, 1), day(2026, 3, 30), day(2026, 4, 6)},
+		{"monthly", utc, PeriodMonthly, now, day(2026, 3, 1), day(2026, 4, 1)},
+		{"monthly across years", utc, PeriodMonthly, day(2026, 12, 31), day(2026, 12, 1), day(2027, 1, 1)},
+		{"reset day ahead", &Config{location: time.UTC, MonthlyResetDay: 15}, PeriodMonthly, now, day(2026, 2, 15), day(2026, 3, 15)},
+		{"on the reset day", &Config{location: time.UTC, MonthlyResetDay: 15}, PeriodMonthly, day(2026, 3, 15), day(2026, 3, 15), day(2026, 4, 15)},
+		{"reset day in january", &Config{location: time.UTC, MonthlyResetDay: 10}, PeriodMonthly, day(2026, 1, 5), day(2025, 12, 10), day(2026, 1, 10)},
+		{"rolling 1d", utc, "1d", now, day(2026, 3, 4), day(2026, 3, 5)},
+		{"rolling 7d", utc, "7d", now, day(2026, 2, 26), day(2026, 3, 5)},
+		{"total", utc, PeriodTotal, now, time.Time{}, time.Time{}},
+		{"unknown", utc, "yearly", now, time.Time{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.conf.PeriodBounds(tt.period, tt.now)
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("PeriodBounds(%q, %v) = %v, %v, want %v, %v", tt.period, tt.now, start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestPeriodBoundsTimezone(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	conf := &Config{location: loc}
+	// Still Sunday in UTC, already Monday in the configured timezone
+	now := time.Date(2026, 3, 8, 22, 0, 0, 0, time.UTC)
+
+	start, end := conf.PeriodBounds(PeriodWeekly, now)
+	if want := time.Date(2026, 3, 9, 0, 0, 0, 0, loc); !start.Equal(want) {
+		t.Errorf("start = %v, want %v", start, want)
+	}
+	if want := time.Date(2026, 3, 16, 0, 0, 0, 0, loc); !end.Equal(want) {
+		t.Errorf("end = %v, want %v", end, want)
+	}
+}
+
+func TestInPeriod(t *testing.T) {
+	conf := &Config{location: time.UTC, MonthlyResetDay: 15}
+	// 2026-03-04 is a Wednesday
+	now := time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		day, period string
+		want        bool
+	}{
+		{"2026-03-04", PeriodDaily, true},
+		{"2026-03-03", PeriodDaily, false},
+		{"2026-03-05", PeriodDaily, false},
+		{"2026-03-02", PeriodWeekly, true},
+		{"2026-03-08", PeriodWeekly, true},
+		{"2026-03-01", PeriodWeekly, false},
+		{"2026-02-15", PeriodMonthly, true},
+		{"2026-02-14", PeriodMonthly, false},
+		{"2026-03-14", PeriodMonthly, true},
+		{"2026-03-15", PeriodMonthly, false},
+		{"2026-02-26", "7d", true},
+		{"2026-02-25", "7d", false},
+		{"2020-01-01", PeriodTotal, true},
+	}
+	for _, tt := range tests {
+		if got := conf.InPeriod(tt.day, tt.period, now); got != tt.want {
+			t.Errorf("InPeriod(%q, %q) = %v, want %v", tt.day, tt.period, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// ModelPolicy limits the models a role may use. A model must match one of the
+// Allow patterns (if any) and none of the Deny patterns, and neither its prompt
+// nor its completion price may exceed MaxPrice USD per million tokens (0 means
+// no limit). Patterns are globs where * matches any characters, including "/",
+// and ? matches one character.
+type ModelPolicy struct {
+	Allow    []string `mapstructure:"allow"`
+	Deny     []string `mapstructure:"deny"`
+	MaxPrice float64  `mapstructure:"max_price"`
+}
+
+// loadModelPolicies reads model_policies from the config, keyed by the role
+// in upper case (USER, GUEST).
+func loadModelPolicies() map[string]ModelPolicy {
+	var raw map[string]ModelPolicy
+	if err := viper.UnmarshalKey("MODEL_POLICIES", &raw); err != nil {
+		log.Printf("Error reading model_policies: %v", err)
+		return nil
+	}
+	policies := make(map[string]ModelPolicy, len(raw))
+	for role, policy := range raw {
+		policies[strings.ToUpper(role)] = policy
+	}
+	return policies
+}
+
+// ModelPolicy returns the policy of the role, false means the role may use
+// any model. Admins are never restricted.
+func (c *Config) ModelPolicy(role string) (ModelPolicy, bool) {
+	if role == "ADMIN" {
+		return ModelPolicy{}, false
+	}
+	policy, ok := c.ModelPolicies[role]
+	return policy, ok
+}
+
+// AllowsName reports whether the model ID passes the Allow and Deny patterns.
+func (p ModelPolicy) AllowsName(model string) bool {
+	for _, pattern := range p.Deny {
+		if globMatch(pattern, model) {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.Allow {
+		if globMatch(pattern, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsPrice reports whether the prompt and the completion prices in USD per
+// million tokens are both within the limit.
+func (p ModelPolicy) AllowsPrice(prompt, completion float64) bool {
+	return p.MaxPrice <= 0 || (prompt <= p.MaxPrice && completion <= p.MaxPrice)
+}
+
+func globMatch(pattern, s string) bool {
+	re := "^" + strings.ReplaceAll(strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*"), `\?`, ".") + "$"
+	matched, err := regexp.MatchString(re, s)
+	return err == nil && matched
+}
@@ -0,0 +1,55 @@
+package config
+
+import "testing"
+
+func TestAllowsName(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ModelPolicy
+		model  string
+		want   bool
+	}{
+		{"no patterns", ModelPolicy{}, "openai/gpt-4o", true},
+		{"allowed", ModelPolicy{Allow: []string{"openai/*"}}, "openai/gpt-4o", true},
+		{"not allowed", ModelPolicy{Allow: []string{"openai/*"}}, "anthropic/claude-3.5-sonnet", false},
+		{"denied", ModelPolicy{Deny: []string{"openai/o1-pro*"}}, "openai/o1-pro", false},
+		{"not denied", ModelPolicy{Deny: []string{"openai/o1-pro*"}}, "openai/o1-mini", true},
+		{"deny wins over allow", ModelPolicy{Allow: []string{"openai/*"}, Deny: []string{"openai/o1*"}}, "openai/o1", false},
+		{"free models", ModelPolicy{Allow: []string{"*:free"}}, "deepseek/deepseek-chat-v3-0324:free", true},
+		{"paid variant of a free model", ModelPolicy{Allow: []string{"*:free"}}, "deepseek/deepseek-chat-v3-0324", false},
+		{"star matches slashes", ModelPolicy{Allow: []string{"*gpt*"}}, "openai/gpt-4o", true},
+		{"question mark", ModelPolicy{Allow: []string{"openai/gpt-?o"}}, "openai/gpt-4o", true},
+		{"question mark is one character", ModelPolicy{Allow: []string{"openai/gpt-?o"}}, "openai/gpt-4.1o", false},
+		{"dot is literal", ModelPolicy{Allow: []string{"openai/gpt-4.1"}}, "openai/gpt-4x1", false},
+		{"plus is literal", ModelPolicy{Allow: []string{"a+b"}}, "aab", false},
+		{"brackets are literal", ModelPolicy{Allow: []string{"model[1]"}}, "model[1]", true},
+		{"whole id must match", ModelPolicy{Allow: []string{"openai/gpt-4o"}}, "openai/gpt-4o-mini", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.AllowsName(tt.model); got != tt.want {
+				t.Errorf("AllowsName(%q) = %v, want %v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowsPrice(t *testing.T) {
+	tests := []struct {
+		maxPrice           float64
+		prompt, completion float64
+		want               bool
+	}{
+		{0, 100, 400, true},
+		{5, 2.5, 5, true},
+		{5, 2.5, 10, false},
+		{5, 10, 1, false},
+		{5, 0, 0, true},
+	}
+	for _, tt := range tests {
+		policy := ModelPolicy{MaxPrice: tt.maxPrice}
+		if got := policy.AllowsPrice(tt.prompt, tt.completion); got != tt.want {
+			t.Errorf("max %g: AllowsPrice(%g, %g) = %v, want %v", tt.maxPrice, tt.prompt, tt.completion, got, tt.want)
+		}
+	}
+}
@@ -2,21 +2,34 @@ package lang
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// BaseLanguage is the reference translation and the last fallback.
+const BaseLanguage = "EN"
+
 var translations map[string]map[string]interface{}
 
-func LoadTranslations(langDir string) error {
-	translations = make(map[string]map[string]interface{})
+// defaultLanguage is the language from the config, used when a string is
+// missing in the user's language.
+var defaultLanguage = BaseLanguage
 
-	languages := []string{"EN", "RU"}
+// LoadTranslations loads every *.json file in langDir, the file name is the
+// language code (EN.json, RU.json, ...). Keys missing or extra compared to
+// the base language are reported in the log.
+func LoadTranslations(langDir string) error {
+	files, err := filepath.Glob(filepath.Join(langDir, "*.json"))
+	if err != nil {
+		return err
+	}
 
-	for _, lang := range languages {
-		filePath := filepath.Join(langDir, lang+".json")
+	loaded := make(map[string]map[string]interface{})
+	for _, filePath := range files {
 		data, err := os.ReadFile(filePath)
 		if err != nil {
 			return err
@@ -25,41 +38,187 @@ func LoadTranslations(langDir string) error {
 		var langMap map[string]interface{}
 		err = json.Unmarshal(data, &langMap)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", filePath, err)
 		}
 
-		translations[lang] = langMap
-	}
-
-	for _, lang := range languages {
-		filePath := filepath.Join(langDir, lang+".json")
+		lang := strings.ToUpper(strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)))
+		loaded[lang] = langMap
 		log.Printf("Loading translations from: %s", filePath)
 	}
+	if _, ok := loaded[BaseLanguage]; !ok {
+		return fmt.Errorf("base language %s not found in %s", BaseLanguage, langDir)
+	}
 
-	//log.Printf("Loaded translations: %+v", translations)
+	translations = loaded
+	CheckTranslations()
 	return nil
 }
 
+// SetDefault sets the language used when a string is missing in the requested one.
+func SetDefault(lang string) {
+	defaultLanguage = strings.ToUpper(lang)
+}
+
+// CheckTranslations logs the keys every language is missing or has in
+// addition to the base language.
+func CheckTranslations() {
+	base := flattenKeys(translations[BaseLanguage], "")
+	for _, lang := range Languages() {
+		if lang == BaseLanguage {
+			continue
+		}
+		keys := flattenKeys(translations[lang], "")
+		if missing := difference(base, keys); len(missing) > 0 {
+			log.Printf("Translations %s: missing keys: %s", lang, strings.Join(missing, ", "))
+		}
+		if extra := difference(keys, base); len(extra) > 0 {
+			log.Printf("Translations %s: extra keys: %s", lang, strings.Join(extra, ", "))
+		}
+	}
+}
+
+// flattenKeys returns the dotted paths of all strings in the map. Plural
+// forms are a leaf: only the key itself is compared, not its forms, as
+// languages have different sets of forms.
+func flattenKeys(m map[string]interface{}, prefix string) map[string]bool {
+	keys := make(map[string]bool)
+	for k, v := range m {
+		path := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok && !isPlural(nested) {
+			for nestedKey := range flattenKeys(nested, path+".") {
+				keys[nestedKey] = true
+			}
+			continue
+		}
+		keys[path] = true
+	}
+	return keys
+}
+
+func difference(a, b map[string]bool) []string {
+	var result []string
+	for k := range a {
+		if !b[k] {
+			result = append(result, k)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Languages returns the codes of the loaded languages.
+func Languages() []string {
+	languages := make([]string, 0, len(translations))
+	for lang := range translations {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Has reports whether translations for the language are loaded.
+func Has(lang string) bool {
+	_, ok := translations[lang]
+	return ok
+}
+
+// Translate returns the string for the key in the given language, falling
+// back to the config language and then to the base language. The key itself
+// is returned if no language has it.
 func Translate(key string, lang string) string {
 	//log.Printf("Translating key: %s, language: %s", key, lang)
 	if translations == nil {
 		log.Println("Translations not loaded. Did you call LoadTranslations?")
 		return key
 	}
-	keys := strings.Split(key, ".")
-	value := interface{}(translations[lang])
+	for _, l := range fallbackChain(lang) {
+		if str, ok := lookup(key, l).(string); ok {
+			return str
+		}
+	}
+	return key
+}
 
-	for _, k := range keys {
-		if m, ok := value.(map[string]interface{}); ok {
-			value = m[k]
-		} else {
-			return key
+// TranslatePlural returns the plural form of the key matching n, formatted
+// with n. The key holds an object with CLDR plural categories, for example
+// {"one": "%d message", "other": "%d messages"}.
+func TranslatePlural(key string, lang string, n int) string {
+	if translations == nil {
+		log.Println("Translations not loaded. Did you call LoadTranslations?")
+		return key
+	}
+	for _, l := range fallbackChain(lang) {
+		forms, ok := lookup(key, l).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		form, ok := forms[pluralCategory(l, n)].(string)
+		if !ok {
+			form, ok = forms["other"].(string)
+		}
+		if ok {
+			return fmt.Sprintf(form, n)
 		}
 	}
+	return key
+}
 
-	if str, ok := value.(string); ok {
-		return str
+func fallbackChain(lang string) []string {
+	chain := []string{strings.ToUpper(lang)}
+	for _, l := range []string{defaultLanguage, BaseLanguage} {
+		if l != chain[len(chain)-1] {
+			chain = append(chain, l)
+		}
 	}
+	return chain
+}
 
-	return key
+func lookup(key string, lang string) interface{} {
+	value := interface{}(translations[lang])
+	for _, k := range strings.Split(key, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[k]
+	}
+	return value
+}
+
+var pluralCategories = map[string]bool{"zero": true, "one": true, "two": true, "few": true, "many": true, "other": true}
+
+func isPlural(m map[string]interface{}) bool {
+	if _, ok := m["other"]; !ok {
+		return false
+	}
+	for k := range m {
+		if !pluralCategories[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// pluralCategory returns the CLDR plural category of an integer n. East
+// Slavic languages have their own rules, other languages use the English ones.
+func pluralCategory(lang string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	switch lang {
+	case "RU", "UK", "BE":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
 }
@@ -0,0 +1,171 @@
+package lang
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+// setTranslations replaces the loaded translations for the duration of the test.
+func setTranslations(t *testing.T, loaded map[string]map[string]interface{}, defaultLang string) {
+	t.Helper()
+	prevTranslations, prevDefault := translations, defaultLanguage
+	t.Cleanup(func() {
+		translations, defaultLanguage = prevTranslations, prevDefault
+	})
+	translations = loaded
+	SetDefault(defaultLang)
+}
+
+func TestPluralCategory(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"RU", 0, "many"},
+		{"RU", 1, "one"},
+		{"RU", 2, "few"},
+		{"RU", 4, "few"},
+		{"RU", 5, "many"},
+		{"RU", 11, "many"},
+		{"RU", 12, "many"},
+		{"RU", 13, "many"},
+		{"RU", 14, "many"},
+		{"RU", 21, "one"},
+		{"RU", 22, "few"},
+		{"RU", 25, "many"},
+		{"RU", 101, "one"},
+		{"RU", 111, "many"},
+		{"RU", 112, "many"},
+		{"RU", -1, "one"},
+		{"UK", 3, "few"},
+		{"EN", 0, "other"},
+		{"EN", 1, "one"},
+		{"EN", 2, "other"},
+		{"EN", 21, "other"},
+	}
+	for _, tt := range tests {
+		if got := pluralCategory(tt.lang, tt.n); got != tt.want {
+			t.Errorf("pluralCategory(%s, %d) = %s, want %s", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTranslatePlural(t *testing.T) {
+	setTranslations(t, map[string]map[string]interface{}{
+		"EN": {"messages": map[string]interface{}{"one": "%d message", "other": "%d messages"}},
+		"RU": {"messages": map[string]interface{}{"one": "%d сообщение", "few": "%d сообщения", "many": "%d сообщений", "other": "%d сообщения"}},
+		"DE": {"messages": map[string]interface{}{"other": "%d Nachrichten"}},
+	}, BaseLanguage)
+
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{"EN", 1, "1 message"},
+		{"EN", 5, "5 messages"},
+		{"RU", 1, "1 сообщение"},
+		{"RU", 2, "2 сообщения"},
+		{"RU", 5, "5 сообщений"},
+		{"RU", 11, "11 сообщений"},
+		{"RU", 21, "21 сообщение"},
+		{"RU", 22, "22 сообщения"},
+		{"RU", 111, "111 сообщений"},
+		// A missing form falls back to "other"
+		{"DE", 1, "1 Nachrichten"},
+		{"FR", 1, "1 message"},
+	}
+	for _, tt := range tests {
+		if got := TranslatePlural("messages", tt.lang, tt.n); got != tt.want {
+			t.Errorf("TranslatePlural(%s, %d) = %q, want %q", tt.lang, tt.n, got, tt.want)
+		}
+	}
+	if got := TranslatePlural("missing", "EN", 1); got != "missing" {
+		t.Errorf("TranslatePlural of a missing key = %q, want the key", got)
+	}
+}
+
+func TestTranslateFallback(t *testing.T) {
+	setTranslations(t, map[string]map[string]interface{}{
+		"EN": {"a": "en a", "b": "en b", "c": "en c", "nested": map[string]interface{}{"d": "en d"}},
+		"RU": {"a": "ru a", "b": "ru b"},
+		"DE": {"a": "de a", "nested": map[string]interface{}{"d": "de d"}},
+	}, "RU")
+
+	tests := []struct {
+		key, lang string
+		want      string
+	}{
+		{"a", "DE", "de a"},
+		{"a", "de", "de a"},
+		// Missing in the user language: the config language
+		{"b", "DE", "ru b"},
+		// Missing in both: the base language
+		{"c", "DE", "en c"},
+		{"nested.d", "DE", "de d"},
+		{"nested.d", "RU", "en d"},
+		{"a", "FR", "ru a"},
+		{"missing", "DE", "missing"},
+		{"a.b", "DE", "a.b"},
+	}
+	for _, tt := range tests {
+		if got := Translate(tt.key, tt.lang); got != tt.want {
+			t.Errorf("Translate(%q, %s) = %q, want %q", tt.key, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	tests := []struct {
+		lang, defaultLang string
+		want              []string
+	}{
+		{"de", "RU", []string{"DE", "RU", "EN"}},
+		{"RU", "RU", []string{"RU", "EN"}},
+		{"EN", "EN", []string{"EN"}},
+		{"DE", "EN", []string{"DE", "EN"}},
+	}
+	for _, tt := range tests {
+		setTranslations(t, nil, tt.defaultLang)
+		if got := fallbackChain(tt.lang); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("fallbackChain(%s) with %s = %v, want %v", tt.lang, tt.defaultLang, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTranslations(t *testing.T) {
+	setTranslations(t, map[string]map[string]interface{}{
+		"EN": {
+			"a":      "a",
+			"nested": map[string]interface{}{"b": "b", "c": "c"},
+			"plural": map[string]interface{}{"one": "%d", "other": "%d"},
+		},
+		"RU": {
+			"a":      "a",
+			"nested": map[string]interface{}{"b": "b", "extra": "x"},
+			// Other plural forms are not reported
+			"plural": map[string]interface{}{"one": "%d", "few": "%d", "many": "%d", "other": "%d"},
+			"unused": "u",
+		},
+	}, BaseLanguage)
+
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	CheckTranslations()
+	out := buf.String()
+
+	if !strings.Contains(out, "Translations RU: missing keys: nested.c\n") {
+		t.Errorf("missing keys not reported:\n%s", out)
+	}
+	if !strings.Contains(out, "Translations RU: extra keys: nested.extra, unused\n") {
+		t.Errorf("extra keys not reported:\n%s", out)
+	}
+	if strings.Contains(out, "plural") {
+		t.Errorf("plural forms reported:\n%s", out)
+	}
+}
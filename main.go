@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"openrouter-bot/api"
 	"openrouter-bot/config"
 	"openrouter-bot/lang"
+	"openrouter-bot/schedule"
 	"openrouter-bot/user"
 	"strconv"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/sashabaranov/go-openai"
 )
 
 func main() {
@@ -26,6 +30,7 @@ func main() {
 	}
 
 	conf := manager.GetConfig()
+	lang.SetDefault(conf.Lang)
 
 	bot, err := tgbotapi.NewBotAPI(conf.TelegramBotToken)
 	if err != nil {
@@ -45,141 +50,736 @@ func main() {
 
 	updates := bot.GetUpdatesChan(u)
 
-	// Set bot commands
-	commands := []tgbotapi.BotCommand{
-		{Command: "start", Description: lang.Translate("description.start", conf.Lang)},
-		{Command: "help", Description: lang.Translate("description.help", conf.Lang)},
-		{Command: "get_models", Description: lang.Translate("description.getModels", conf.Lang)},
-		{Command: "set_model", Description: lang.Translate("description.setModel", conf.Lang)},
-		{Command: "reset", Description: lang.Translate("description.reset", conf.Lang)},
-		{Command: "stats", Description: lang.Translate("description.stats", conf.Lang)},
-		{Command: "stop", Description: lang.Translate("description.stop", conf.Lang)},
-	}
-	_, err = bot.Request(tgbotapi.NewSetMyCommands(commands...))
+	// Set bot commands, the default menu in the config language and a localized one per language
+	_, err = bot.Request(tgbotapi.NewSetMyCommands(botCommands(strings.ToUpper(conf.Lang))...))
 	if err != nil {
 		log.Fatalf("Failed to set bot commands: %v", err)
 	}
+	for _, language := range lang.Languages() {
+		_, err = bot.Request(tgbotapi.NewSetMyCommandsWithScopeAndLanguage(
+			tgbotapi.NewBotCommandScopeDefault(), strings.ToLower(language), botCommands(language)...))
+		if err != nil {
+			log.Printf("Failed to set bot commands for language %s: %v", language, err)
+		}
+	}
+	// Admins also get the admin commands, in the config language
+	for _, adminID := range conf.AdminChatIDs {
+		_, err = bot.Request(tgbotapi.NewSetMyCommandsWithScope(
+			tgbotapi.NewBotCommandScopeChat(adminID), adminCommands(strings.ToUpper(conf.Lang))...))
+		if err != nil {
+			log.Printf("Failed to set admin commands for %d: %v", adminID, err)
+		}
+	}
 
-	clientOptions := openai.DefaultConfig(conf.OpenAIApiKey)
-	clientOptions.BaseURL = conf.OpenAIBaseURL
-	client := openai.NewClientWithConfig(clientOptions)
-
+	catalog := api.NewCatalog(conf.OpenAIBaseURL, time.Duration(conf.ModelsCacheTTL)*time.Minute)
+	catalog.Start()
 	userManager := user.NewUserManager("logs")
+	providers := api.NewProviders(conf, catalog, "logs", userManager)
+	providers.OnCharge(api.NewBudgetAlerts(bot, conf, userManager, "logs").Check)
+	providers.Start()
+	inlineResponder := api.NewInlineResponder(bot, providers)
+	modelBrowser := api.NewModelBrowser(bot, catalog)
+
+	scheduler, err := schedule.NewScheduler("logs", conf.Location(), func(job schedule.Job) {
+		userStats := userManager.GetUser(job.UserID, job.UserName, conf)
+		enqueue(bot, job.ChatID, conf, userStats, func(ctx context.Context, statusID int) {
+			runScheduledPrompt(ctx, bot, providers, job, conf, userStats, statusID)
+		})
+	})
+	if err != nil {
+		log.Fatalf("Error loading schedules: %v", err)
+	}
+	scheduler.Start()
 
 	for update := range updates {
+		if update.InlineQuery != nil {
+			userStats := userManager.GetUser(update.SentFrom().ID, update.SentFrom().UserName, conf)
+			userStats.SetLanguageCode(update.SentFrom().LanguageCode)
+			inlineResponder.Handle(update.InlineQuery, conf, userStats)
+			continue
+		}
+		if update.CallbackQuery != nil {
+			userStats := userManager.GetUser(update.SentFrom().ID, update.SentFrom().UserName, conf)
+			userStats.SetLanguageCode(update.SentFrom().LanguageCode)
+			handleCallback(bot, update.CallbackQuery, modelBrowser, conf, userStats)
+			continue
+		}
 		if update.Message == nil {
 			continue
 		}
 		userStats := userManager.GetUser(update.SentFrom().ID, update.SentFrom().UserName, conf)
+		userStats.SetLanguageCode(update.SentFrom().LanguageCode)
+		userLang := userStats.Lang(conf)
 		//userStats.AddCost(0.0)
 		if update.Message.IsCommand() {
 			switch update.Message.Command() {
 			case "start":
-				msgText := lang.Translate("commands.start", conf.Lang) + lang.Translate("commands.help", conf.Lang) + lang.Translate("commands.start_end", conf.Lang)
+				msgText := lang.Translate("commands.start", userLang) + lang.Translate("commands.help", userLang) + lang.Translate("commands.start_end", userLang)
 				msg := tgbotapi.NewMessage(update.Message.Chat.ID, msgText)
 				msg.ParseMode = "HTML"
 				bot.Send(msg)
 			case "help":
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.help", conf.Lang))
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.help", userLang))
+				if userStats.GetUserRole(conf) == "ADMIN" {
+					msg.Text += "\n\n" + lang.Translate("commands.helpAdmin", userLang)
+				}
 				msg.ParseMode = "HTML"
 				bot.Send(msg)
 			case "get_models":
-				models, _ := api.GetFreeModels()
-				if err != nil {
-					fmt.Printf("Error: %v\n", err)
-					return
-				}
-				// fmt.Println(models)
-				text := lang.Translate("commands.getModels", conf.Lang) + models
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, text)
-				msg.ParseMode = tgbotapi.ModeMarkdown
-				_, err := bot.Send(msg)
-				if err != nil {
-					fmt.Printf("Error: %v\n", err)
-					return
-				}
+				go modelBrowser.Open(update.Message.Chat.ID, update.SentFrom().ID, update.Message.CommandArguments(), userLang)
 			case "set_model":
 				args := update.Message.CommandArguments()
 				argsArr := strings.Split(args, " ")
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, conf.Model.ModelName)
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, userStats.Model(conf))
 				msg.ParseMode = tgbotapi.ModeMarkdown
+				model := conf.ResolveModel(argsArr[0])
 				switch {
 				case args == "default":
-					conf.Model.ModelName = conf.Model.ModelNameDefault
-					msg.Text = lang.Translate("commands.setModel", conf.Lang) + " `" + conf.Model.ModelName + "`"
+					userStats.SetModel("")
+					msg.Text = lang.Translate("commands.setModel", userLang) + " `" + userStats.Model(conf) + "`"
 				case args == "":
-					msg.Text = lang.Translate("commands.noArgsModel", conf.Lang)
+					msg.Text = lang.Translate("commands.noArgsModel", userLang)
 				case len(argsArr) > 1:
-					msg.Text = lang.Translate("commands.noSpaceModel", conf.Lang)
+					msg.Text = lang.Translate("commands.noSpaceModel", userLang)
+				case !providers.Known(model):
+					msg.Text = fmt.Sprintf(lang.Translate("commands.unknownModel", userLang), html.EscapeString(model))
+					if suggestions := catalog.Suggest(model, 3); len(suggestions) > 0 {
+						msg.Text += "\n\n" + lang.Translate("commands.didYouMean", userLang)
+						for _, suggestion := range suggestions {
+							msg.Text += "\n<code>/set_model " + html.EscapeString(suggestion) + "</code>"
+						}
+					}
+					msg.ParseMode = "HTML"
+				case !catalog.Allowed(conf, userStats.GetUserRole(conf), model):
+					msg.Text = fmt.Sprintf(lang.Translate("commands.modelNotAllowed", userLang), html.EscapeString(model))
+					msg.ParseMode = "HTML"
 				default:
-					conf.Model.ModelName = argsArr[0]
-					msg.Text = lang.Translate("commands.setModel", conf.Lang) + " `" + conf.Model.ModelName + "`"
+					userStats.SetModel(model)
+					msg.Text = lang.Translate("commands.setModel", userLang) + " `" + model + "`"
+					if m, ok := catalog.Lookup(model); ok && !m.IsFree() && userStats.BudgetLow(conf) {
+						msg.Text += "\n\n" + lang.Translate("commands.lowBudgetModel", userLang)
+					}
 				}
 				bot.Send(msg)
 			case "reset":
 				args := update.Message.CommandArguments()
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+				chatID := update.Message.Chat.ID
+				if args == "" {
+					enqueueHistory(bot, chatID, conf, userStats, func() {
+						userStats.ClearHistory()
+						bot.Send(tgbotapi.NewMessage(chatID, lang.Translate("commands.reset", userLang)))
+					})
+					break
+				}
+				msg := tgbotapi.NewMessage(chatID, "")
 				if args == "system" {
 					userStats.SystemPrompt = conf.SystemPrompt
-					msg.Text = lang.Translate("commands.reset_system", conf.Lang)
-				} else if args != "" {
+					msg.Text = lang.Translate("commands.reset_system", userLang)
+				} else {
 					userStats.SystemPrompt = args
-					msg.Text = lang.Translate("commands.reset_prompt", conf.Lang) + args + "."
+					msg.Text = lang.Translate("commands.reset_prompt", userLang) + args + "."
+				}
+				bot.Send(msg)
+			case "export":
+				format := strings.ToLower(strings.TrimSpace(update.Message.CommandArguments()))
+				if format == "" {
+					format = user.ExportMarkdown
+				}
+				if len(userStats.GetMessages()) == 0 {
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.export_empty", userLang)))
+					break
+				}
+				name, data, err := userStats.Export(format)
+				if err != nil {
+					log.Printf("Failed to export conversation for user %s: %v", userStats.UserID, err)
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.export_err", userLang))
+					msg.ParseMode = "HTML"
+					bot.Send(msg)
+					break
+				}
+				doc := tgbotapi.NewDocument(update.Message.Chat.ID, tgbotapi.FileBytes{Name: name, Bytes: data})
+				if _, err := bot.Send(doc); err != nil {
+					log.Printf("Failed to send export: %v", err)
+				}
+			case "import":
+				var doc *tgbotapi.Document
+				if update.Message.ReplyToMessage != nil {
+					doc = update.Message.ReplyToMessage.Document
+				}
+				name := strings.TrimSpace(update.Message.CommandArguments())
+				enqueueImport(bot, update.Message.Chat.ID, doc, name, conf, userStats)
+			case "compare":
+				models, prompt, ok := api.ParseCompareArgs(update.Message.CommandArguments(), conf, providers)
+				unknown := unknownModel(models, providers)
+				denied := deniedModel(models, catalog, conf, userStats.GetUserRole(conf))
+				switch {
+				case !ok:
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("compare.usage", userLang))
+					msg.ParseMode = "HTML"
+					bot.Send(msg)
+				case unknown != "":
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(lang.Translate("commands.unknownModel", userLang), html.EscapeString(unknown)))
+					msg.ParseMode = "HTML"
+					bot.Send(msg)
+				case denied != "":
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(lang.Translate("commands.modelNotAllowed", userLang), html.EscapeString(denied)))
+					msg.ParseMode = "HTML"
+					bot.Send(msg)
+				case !userStats.HaveAccess(conf):
+					bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("budget_out", userLang)))
+				default:
+					go api.HandleCompare(bot, providers, update.Message.Chat.ID, models, prompt, conf, userStats)
+				}
+			case "search":
+				query := strings.TrimSpace(update.Message.CommandArguments())
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+				msg.ParseMode = "HTML"
+				if query == "" {
+					msg.Text = lang.Translate("commands.search_usage", userLang)
+				} else if results := userStats.Search(query, 5); len(results) == 0 {
+					msg.Text = lang.Translate("commands.search_empty", userLang)
 				} else {
-					userStats.ClearHistory()
-					msg.Text = lang.Translate("commands.reset", conf.Lang)
+					msg.Text, msg.ReplyMarkup = searchResultsMessage(query, results, userLang)
+				}
+				bot.Send(msg)
+			case "schedule":
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+				msg.ParseMode = "HTML"
+				spec, prompt, ok := schedule.SplitArgs(update.Message.CommandArguments())
+				if !ok {
+					msg.Text = lang.Translate("schedule.usage", userLang)
+					bot.Send(msg)
+					break
+				}
+				job, err := scheduler.Add(schedule.Job{
+					UserID:   update.SentFrom().ID,
+					UserName: update.SentFrom().UserName,
+					ChatID:   update.Message.Chat.ID,
+					Spec:     spec,
+					Prompt:   prompt,
+				})
+				switch {
+				case errors.Is(err, schedule.ErrTooManyJobs):
+					msg.Text = fmt.Sprintf(lang.Translate("schedule.tooMany", userLang), schedule.MaxJobsPerUser)
+				case err != nil:
+					msg.Text = fmt.Sprintf(lang.Translate("schedule.invalid", userLang), html.EscapeString(err.Error())) +
+						"\n\n" + lang.Translate("schedule.usage", userLang)
+				default:
+					msg.Text = fmt.Sprintf(lang.Translate("schedule.added", userLang),
+						html.EscapeString(job.Spec), scheduler.NextRun(*job).Format("2006-01-02 15:04"))
+				}
+				bot.Send(msg)
+			case "schedules":
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("schedule.empty", userLang))
+				msg.ParseMode = "HTML"
+				if jobs := scheduler.List(update.SentFrom().ID); len(jobs) > 0 {
+					var text strings.Builder
+					text.WriteString(lang.Translate("schedule.list", userLang))
+					for i, job := range jobs {
+						text.WriteString(fmt.Sprintf("\n\n%d. <code>%s</code> (%s)\n%s", i+1, html.EscapeString(job.Spec),
+							scheduler.NextRun(job).Format("2006-01-02 15:04"), html.EscapeString(job.Prompt)))
+					}
+					msg.Text = text.String()
+				}
+				bot.Send(msg)
+			case "unschedule":
+				arg := strings.TrimSpace(update.Message.CommandArguments())
+				n, err := strconv.Atoi(arg)
+				if arg == "all" {
+					n, err = 0, nil
+				} else if err == nil && n < 1 {
+					err = fmt.Errorf("invalid number: %d", n)
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+				msg.ParseMode = "HTML"
+				if err == nil {
+					removed := scheduler.Remove(update.SentFrom().ID, n)
+					msg.Text = fmt.Sprintf(lang.Translate("schedule.removed", userLang), removed)
+				} else {
+					msg.Text = lang.Translate("schedule.unscheduleUsage", userLang)
+				}
+				bot.Send(msg)
+			case "pin":
+				text := strings.TrimSpace(update.Message.CommandArguments())
+				if reply := update.Message.ReplyToMessage; text == "" && reply != nil {
+					text = strings.TrimSpace(reply.Text + reply.Caption)
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+				msg.ParseMode = "HTML"
+				if text == "" {
+					msg.Text = lang.Translate("pins.usage", userLang)
+					bot.Send(msg)
+					break
+				}
+				// Pinned messages may take at most half of the context, the rest is left for the conversation
+				switch err := userStats.AddPin(text, conf.MaxContextTokens/2); {
+				case errors.Is(err, user.ErrTooManyPins):
+					msg.Text = fmt.Sprintf(lang.Translate("pins.tooMany", userLang), user.MaxPins)
+				case errors.Is(err, user.ErrPinTooLarge):
+					msg.Text = fmt.Sprintf(lang.Translate("pins.tooLarge", userLang), conf.MaxContextTokens/2)
+				default:
+					msg.Text = fmt.Sprintf(lang.Translate("pins.added", userLang), len(userStats.Pins()))
+				}
+				bot.Send(msg)
+			case "pins":
+				pins := userStats.Pins()
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("pins.empty", userLang))
+				msg.ParseMode = "HTML"
+				if len(pins) > 0 {
+					var sb strings.Builder
+					sb.WriteString(fmt.Sprintf(lang.Translate("pins.list", userLang), user.PinnedTokens(pins)))
+					for i, pin := range pins {
+						sb.WriteString(fmt.Sprintf("\n\n<b>%d.</b> %s", i+1, html.EscapeString(pin)))
+					}
+					msg.Text = sb.String()
+				}
+				bot.Send(msg)
+			case "unpin":
+				arg := strings.TrimSpace(update.Message.CommandArguments())
+				n, err := strconv.Atoi(arg)
+				if arg == "all" {
+					n, err = 0, nil
+				} else if err == nil && n < 1 {
+					err = fmt.Errorf("invalid number: %d", n)
+				}
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+				msg.ParseMode = "HTML"
+				if err == nil {
+					removed := userStats.RemovePin(n)
+					msg.Text = fmt.Sprintf(lang.Translate("pins.removed", userLang), removed)
+				} else {
+					msg.Text = lang.Translate("pins.unpinUsage", userLang)
+				}
+				bot.Send(msg)
+			case "lang":
+				arg := strings.ToUpper(strings.TrimSpace(update.Message.CommandArguments()))
+				available := strings.Join(lang.Languages(), ", ")
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+				msg.ParseMode = "HTML"
+				switch {
+				case arg == "":
+					msg.Text = fmt.Sprintf(lang.Translate("commands.lang", userLang), userLang, available)
+				case arg == "AUTO" || lang.Has(arg):
+					if arg == "AUTO" {
+						arg = ""
+					}
+					userStats.UpdateSettings(func(s *user.UserSettings) {
+						s.Lang = arg
+					})
+					userLang = userStats.Lang(conf)
+					msg.Text = fmt.Sprintf(lang.Translate("commands.lang_set", userLang), userLang)
+				default:
+					msg.Text = fmt.Sprintf(lang.Translate("commands.lang_err", userLang), html.EscapeString(arg), available)
+				}
+				bot.Send(msg)
+			case "files":
+				args := strings.Fields(update.Message.CommandArguments())
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+				msg.ParseMode = "HTML"
+				mode, threshold, ok := parseFileArgs(args)
+				if ok {
+					userStats.UpdateSettings(func(s *user.UserSettings) {
+						if mode != "" {
+							s.FileMode = mode
+						}
+						if threshold > 0 {
+							s.FileThreshold = threshold
+						}
+					})
+					mode, threshold = userStats.FileDelivery(conf)
+					msg.Text = fmt.Sprintf(lang.Translate("commands.files", userLang), mode, threshold)
+				} else {
+					msg.Text = lang.Translate("commands.files_err", userLang)
 				}
 				bot.Send(msg)
 			case "stats":
 				userStats.CheckHistory(conf.MaxHistorySize, conf.MaxHistoryTime)
-				countedUsage := strconv.FormatFloat(userStats.GetCurrentCost(conf.BudgetPeriod), 'f', 6, 64)
-				todayUsage := strconv.FormatFloat(userStats.GetCurrentCost("daily"), 'f', 6, 64)
-				monthUsage := strconv.FormatFloat(userStats.GetCurrentCost("monthly"), 'f', 6, 64)
-				totalUsage := strconv.FormatFloat(userStats.GetCurrentCost("total"), 'f', 6, 64)
-				messagesCount := strconv.Itoa(len(userStats.GetMessages()))
+				countedUsage := strconv.FormatFloat(userStats.GetCurrentCost(conf, userStats.BudgetPeriod(conf)), 'f', 6, 64)
+				todayUsage := strconv.FormatFloat(userStats.GetCurrentCost(conf, config.PeriodDaily), 'f', 6, 64)
+				monthUsage := strconv.FormatFloat(userStats.GetCurrentCost(conf, config.PeriodMonthly), 'f', 6, 64)
+				totalUsage := strconv.FormatFloat(userStats.GetCurrentCost(conf, config.PeriodTotal), 'f', 6, 64)
+				messagesCount := lang.TranslatePlural("plural.messages", userLang, len(userStats.GetMessages()))
 
 				var statsMessage string
 				if userStats.CanViewStats(conf) {
 					statsMessage = fmt.Sprintf(
-						lang.Translate("commands.stats", conf.Lang),
-						countedUsage, todayUsage, monthUsage, totalUsage, messagesCount)
+						lang.Translate("commands.stats", userLang),
+						countedUsage, todayUsage, monthUsage, totalUsage,
+						userStats.GetCurrentTokens(conf, config.PeriodDaily), userStats.GetCurrentTokens(conf, config.PeriodMonthly), messagesCount)
+					if budget, limited := userStats.Budget(conf); limited {
+						remaining, _ := userStats.Remaining(conf)
+						statsMessage += "\n" + fmt.Sprintf(lang.Translate("budget.stats", userLang), userStats.BudgetPeriod(conf), budget, max(remaining, 0))
+						if _, ok := userStats.BudgetOverride(); ok {
+							statsMessage += " — " + lang.Translate("budget.personal", userLang)
+						}
+					}
 				} else {
 					statsMessage = fmt.Sprintf(
-						lang.Translate("commands.stats_min", conf.Lang), messagesCount)
+						lang.Translate("commands.stats_min", userLang), messagesCount)
 				}
 
 				msg := tgbotapi.NewMessage(update.Message.Chat.ID, statsMessage)
 				msg.ParseMode = "HTML"
 				bot.Send(msg)
 
+			case "estimate":
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, estimateText(providers, update.Message.CommandArguments(), conf, userStats))
+				msg.ParseMode = "HTML"
+				bot.Send(msg)
+			case "set_budget":
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, setBudget(userManager, update.Message.CommandArguments(), conf, userStats))
+				msg.ParseMode = "HTML"
+				bot.Send(msg)
+			case "unreconciled":
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, unreconciledText(providers, userStats.GetUserRole(conf), userLang))
+				msg.ParseMode = "HTML"
+				bot.Send(msg)
+
 			case "stop":
-				if userStats.CurrentStream != nil {
-					userStats.CurrentStream.Close()
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.stop", conf.Lang))
+				if active, dropped := userStats.Stop(); active || dropped > 0 {
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.stop", userLang))
 					bot.Send(msg)
 				} else {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.stop_err", conf.Lang))
+					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("commands.stop_err", userLang))
 					bot.Send(msg)
 				}
 			}
+		} else if name, ok := importCaption(update.Message); ok {
+			enqueueImport(bot, update.Message.Chat.ID, update.Message.Document, name, conf, userStats)
 		} else {
-			go func(userStats *user.UsageTracker) {
-				// Handle user message
-				if userStats.HaveAccess(conf) {
-					responseID := api.HandleChatGPTStreamResponse(bot, client, update.Message, conf, userStats)
-					if conf.Model.Type == "openrouter" {
-						userStats.GetUsageFromApi(responseID, conf)
-					}
-				} else {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, lang.Translate("budget_out", conf.Lang))
-					_, err := bot.Send(msg)
-					if err != nil {
-						log.Println(err)
-					}
-				}
+			message := update.Message
+			enqueue(bot, message.Chat.ID, conf, userStats, func(ctx context.Context, statusID int) {
+				handleMessage(ctx, bot, providers, message, conf, userStats, statusID)
+			})
+		}
+	}
+
+}
+
+// estimateText describes the projected cost of sending text as the next message.
+func estimateText(providers *api.Providers, text string, conf *config.Config, userStats *user.UsageTracker) string {
+	userLang := userStats.Lang(conf)
+	est := providers.EstimateNext(text, conf, userStats)
+	lines := []string{fmt.Sprintf(lang.Translate("estimate.result", userLang),
+		html.EscapeString(est.Model), est.PromptTokens, est.MaxTokens, est.PromptCost, est.WorstCost)}
+	if est.Limited {
+		lines = append(lines, fmt.Sprintf(lang.Translate("estimate.remaining", userLang), max(est.Remaining, 0)))
+	}
+	if tokens, ok := est.Affordable(); !ok {
+		lines = append(lines, lang.Translate("estimate.notAffordable", userLang))
+	} else if tokens < est.MaxTokens {
+		lines = append(lines, fmt.Sprintf(lang.Translate("estimate.lowered", userLang), tokens))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// setBudget handles /set_budget <user> <amount|+grant|reset> [period] for
+// admins and returns the reply.
+func setBudget(userManager *user.Manager, args string, conf *config.Config, userStats *user.UsageTracker) string {
+	userLang := userStats.Lang(conf)
+	if userStats.GetUserRole(conf) != "ADMIN" {
+		return lang.Translate("commands.adminOnly", userLang)
+	}
+	fields := strings.Fields(args)
+	if len(fields) < 2 || len(fields) > 3 {
+		return lang.Translate("budget.usage", userLang)
+	}
+	target, ok := userManager.Find(fields[0], conf)
+	if !ok {
+		return fmt.Sprintf(lang.Translate("budget.notFound", userLang), html.EscapeString(fields[0]))
+	}
+	name := html.EscapeString(fields[0])
+
+	value := fields[1]
+	switch {
+	case value == "reset" && len(fields) == 2:
+		target.ResetBudget()
+		return fmt.Sprintf(lang.Translate("budget.reset", userLang), name)
+	case strings.HasPrefix(value, "+") && len(fields) == 2:
+		amount, err := strconv.ParseFloat(value[1:], 64)
+		if err != nil || amount <= 0 {
+			return lang.Translate("budget.invalid", userLang)
+		}
+		target.GrantBudget(amount)
+		budget, _ := target.Budget(conf)
+		return fmt.Sprintf(lang.Translate("budget.granted", userLang), amount, name, budget)
+	}
+
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || amount < 0 {
+		return lang.Translate("budget.invalid", userLang)
+	}
+	var period string
+	if len(fields) == 3 {
+		period = strings.ToLower(fields[2])
+	}
+	if err := target.SetBudget(amount, period); err != nil {
+		return lang.Translate("budget.invalid", userLang)
+	}
+	return fmt.Sprintf(lang.Translate("budget.set", userLang), name, amount, target.BudgetPeriod(conf))
+}
+
+// unreconciledText lists the costs still charged with an estimate, for admins.
+func unreconciledText(providers *api.Providers, role, userLang string) string {
+	if role != "ADMIN" {
+		return lang.Translate("commands.adminOnly", userLang)
+	}
+	// The oldest ones are enough to keep the message under the Telegram limit
+	pending, total := providers.Unreconciled(30)
+	if total == 0 {
+		return lang.Translate("reconcile.empty", userLang)
+	}
+
+	lines := []string{fmt.Sprintf(lang.Translate("reconcile.header", userLang), total)}
+	for _, p := range pending {
+		line := fmt.Sprintf(lang.Translate("reconcile.item", userLang),
+			html.EscapeString(p.GenerationID), html.EscapeString(p.Model), p.UserID,
+			p.Provisional, p.Created.Format("2006-01-02 15:04"), p.Attempts)
+		if p.Failed {
+			line += " — " + lang.Translate("reconcile.failed", userLang)
+		} else if p.LastError != "" {
+			line += " — " + html.EscapeString(p.LastError)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// botCommands returns the bot command menu in the given language.
+func botCommands(language string) []tgbotapi.BotCommand {
+	return []tgbotapi.BotCommand{
+		{Command: "start", Description: lang.Translate("description.start", language)},
+		{Command: "help", Description: lang.Translate("description.help", language)},
+		{Command: "get_models", Description: lang.Translate("description.getModels", language)},
+		{Command: "set_model", Description: lang.Translate("description.setModel", language)},
+		{Command: "reset", Description: lang.Translate("description.reset", language)},
+		{Command: "export", Description: lang.Translate("description.export", language)},
+		{Command: "import", Description: lang.Translate("description.import", language)},
+		{Command: "compare", Description: lang.Translate("description.compare", language)},
+		{Command: "search", Description: lang.Translate("description.search", language)},
+		{Command: "schedule", Description: lang.Translate("description.schedule", language)},
+		{Command: "schedules", Description: lang.Translate("description.schedules", language)},
+		{Command: "unschedule", Description: lang.Translate("description.unschedule", language)},
+		{Command: "pin", Description: lang.Translate("description.pin", language)},
+		{Command: "pins", Description: lang.Translate("description.pins", language)},
+		{Command: "unpin", Description: lang.Translate("description.unpin", language)},
+		{Command: "files", Description: lang.Translate("description.files", language)},
+		{Command: "lang", Description: lang.Translate("description.lang", language)},
+		{Command: "stats", Description: lang.Translate("description.stats", language)},
+		{Command: "estimate", Description: lang.Translate("description.estimate", language)},
+		{Command: "stop", Description: lang.Translate("description.stop", language)},
+	}
+}
+
+// adminCommands returns the command menu of admins in the given language.
+func adminCommands(language string) []tgbotapi.BotCommand {
+	return append(botCommands(language),
+		tgbotapi.BotCommand{Command: "set_budget", Description: lang.Translate("description.setBudget", language)},
+		tgbotapi.BotCommand{Command: "unreconciled", Description: lang.Translate("description.unreconciled", language)},
+	)
+}
+
+// enqueue adds a turn to the user's queue, so that the requests of a user are
+// answered one at a time. If other requests are ahead, a status message is
+// shown and passed to run as statusID, otherwise statusID is 0.
+func enqueue(bot *tgbotapi.BotAPI, chatID int64, conf *config.Config, userStats *user.UsageTracker, run func(ctx context.Context, statusID int)) {
+	userLang := userStats.Lang(conf)
+	// The status message is sent after the turn is queued, the turn waits for it
+	var statusID int
+	ready := make(chan struct{})
+	ahead, err := userStats.Enqueue(func(ctx context.Context) {
+		<-ready
+		run(ctx, statusID)
+	}, func() {
+		<-ready
+		if statusID != 0 {
+			bot.Send(tgbotapi.NewEditMessageText(chatID, statusID, lang.Translate("queue.cancelled", userLang)))
+		}
+	}, conf.MaxQueueDepth)
+	if errors.Is(err, user.ErrQueueFull) {
+		close(ready)
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(lang.Translate("queue.full", userLang), conf.MaxQueueDepth)))
+		return
+	}
+	if ahead > 0 {
+		sent, err := bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(lang.Translate("queue.queued", userLang), ahead)))
+		if err == nil {
+			statusID = sent.MessageID
+		}
+	}
+	close(ready)
+}
+
+// enqueueImport queues an import, as it replaces the history the running turn works on.
+func enqueueImport(bot *tgbotapi.BotAPI, chatID int64, doc *tgbotapi.Document, name string, conf *config.Config, userStats *user.UsageTracker) {
+	enqueueHistory(bot, chatID, conf, userStats, func() {
+		api.HandleImport(bot, chatID, doc, name, conf, userStats)
+	})
+}
+
+// enqueueHistory queues a change of the history (reset, restore, adopt...)
+// behind the running turn, which would otherwise interleave with it.
+func enqueueHistory(bot *tgbotapi.BotAPI, chatID int64, conf *config.Config, userStats *user.UsageTracker, change func()) {
+	enqueue(bot, chatID, conf, userStats, func(ctx context.Context, statusID int) {
+		if statusID != 0 {
+			bot.Request(tgbotapi.NewDeleteMessage(chatID, statusID))
+		}
+		change()
+	})
+}
 
-			}(userStats)
+// handleMessage sends the message to the model and charges the user for it.
+func handleMessage(ctx context.Context, bot *tgbotapi.BotAPI, providers *api.Providers, message *tgbotapi.Message, conf *config.Config, userStats *user.UsageTracker, statusID int) {
+	if userStats.HaveAccess(conf) {
+		api.HandleChatGPTStreamResponse(ctx, bot, providers, message, conf, userStats, statusID)
+	} else {
+		var msg tgbotapi.Chattable = tgbotapi.NewMessage(message.Chat.ID, lang.Translate("budget_out", userStats.Lang(conf)))
+		if statusID != 0 {
+			msg = tgbotapi.NewEditMessageText(message.Chat.ID, statusID, lang.Translate("budget_out", userStats.Lang(conf)))
+		}
+		_, err := bot.Send(msg)
+		if err != nil {
+			log.Println(err)
 		}
 	}
+}
 
+// runScheduledPrompt sends a scheduled prompt as if the user wrote it.
+func runScheduledPrompt(ctx context.Context, bot *tgbotapi.BotAPI, providers *api.Providers, job schedule.Job, conf *config.Config, userStats *user.UsageTracker, statusID int) {
+	msg := tgbotapi.NewMessage(job.ChatID, fmt.Sprintf(lang.Translate("schedule.running", userStats.Lang(conf)), html.EscapeString(job.Prompt)))
+	msg.ParseMode = "HTML"
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Failed to send scheduled prompt %s: %v", job.ID, err)
+		return
+	}
+	// The answer goes below the prompt rather than into the older queue status
+	if statusID != 0 {
+		bot.Request(tgbotapi.NewDeleteMessage(job.ChatID, statusID))
+	}
+	message := &tgbotapi.Message{
+		From: &tgbotapi.User{ID: job.UserID, UserName: job.UserName},
+		Chat: &tgbotapi.Chat{ID: job.ChatID},
+		Text: job.Prompt,
+	}
+	handleMessage(ctx, bot, providers, message, conf, userStats, 0)
+}
+
+// unknownModel returns the first of the models no provider knows.
+func unknownModel(models []string, providers *api.Providers) string {
+	for _, model := range models {
+		if !providers.Known(model) {
+			return model
+		}
+	}
+	return ""
+}
+
+// deniedModel returns the first of the models the role may not use.
+func deniedModel(models []string, catalog *api.Catalog, conf *config.Config, role string) string {
+	for _, model := range models {
+		if !catalog.Allowed(conf, role, model) {
+			return model
+		}
+	}
+	return ""
+}
+
+// parseFileArgs parses "/files [off|long|code] [threshold]" arguments.
+func parseFileArgs(args []string) (string, int, bool) {
+	var mode string
+	var threshold int
+	if len(args) > 2 {
+		return "", 0, false
+	}
+	if len(args) > 0 {
+		mode = strings.ToLower(args[0])
+		if mode != user.FileModeOff && mode != user.FileModeLong && mode != user.FileModeCode {
+			return "", 0, false
+		}
+	}
+	if len(args) > 1 {
+		value, err := strconv.Atoi(args[1])
+		if err != nil || value <= 0 {
+			return "", 0, false
+		}
+		threshold = value
+	}
+	return mode, threshold, true
+}
+
+// importCaption reports whether the message is a document sent with an
+// "/import [name]" caption and returns the conversation name.
+func importCaption(message *tgbotapi.Message) (string, bool) {
+	if message.Document == nil {
+		return "", false
+	}
+	fields := strings.Fields(message.Caption)
+	if len(fields) == 0 || (fields[0] != "/import" && !strings.HasPrefix(fields[0], "/import@")) {
+		return "", false
+	}
+	return strings.Join(fields[1:], " "), true
+}
+
+// searchResultsMessage lists search results with a button per conversation to restore it.
+func searchResultsMessage(query string, results []user.SearchResult, language string) (string, tgbotapi.InlineKeyboardMarkup) {
+	var text strings.Builder
+	var rows [][]tgbotapi.InlineKeyboardButton
+	text.WriteString(fmt.Sprintf(lang.Translate("commands.search", language), html.EscapeString(query)))
+	for i, result := range results {
+		title := result.Title
+		if result.Current {
+			title += " ✅"
+		}
+		text.WriteString(fmt.Sprintf("\n\n%d. <b>%s</b> (%s)\n<i>%s</i>",
+			i+1, html.EscapeString(title), result.Updated.Format("2006-01-02"), html.EscapeString(result.Snippet)))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d. %s", i+1, title), "restore:"+result.ConversationID)))
+	}
+	return text.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleCallback handles inline keyboard button presses.
+func handleCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, modelBrowser *api.ModelBrowser, conf *config.Config, userStats *user.UsageTracker) {
+	action, arg, _ := strings.Cut(query.Data, ":")
+	answer := ""
+	language := userStats.Lang(conf)
+	chatID := query.From.ID
+	if query.Message != nil {
+		chatID = query.Message.Chat.ID
+	}
+
+	switch action {
+	case "mb":
+		answer = modelBrowser.HandleCallback(query, arg, conf, userStats, language)
+	case "restore":
+		enqueueHistory(bot, chatID, conf, userStats, func() {
+			msg := tgbotapi.NewMessage(chatID, lang.Translate("commands.restore_err", language))
+			if conv := userStats.RestoreConversation(arg, conf.MaxHistorySize); conv != nil {
+				msg.Text = fmt.Sprintf(lang.Translate("commands.restore_done", language),
+					html.EscapeString(conv.Title()), len(userStats.GetMessages()))
+			}
+			msg.ParseMode = "HTML"
+			bot.Send(msg)
+		})
+	case "adopt":
+		id, indexStr, _ := strings.Cut(arg, ":")
+		index, _ := strconv.Atoi(indexStr)
+		enqueueHistory(bot, chatID, conf, userStats, func() {
+			adopted, ok := userStats.AdoptComparison(id, index)
+			if !ok {
+				bot.Send(tgbotapi.NewMessage(chatID, lang.Translate("compare.adopt_err", language)))
+				return
+			}
+			text := fmt.Sprintf(lang.Translate("compare.adopted", language), html.EscapeString(adopted.Model))
+			if query.Message != nil {
+				edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, text)
+				edit.ParseMode = "HTML"
+				bot.Send(edit)
+			}
+		})
+	}
+
+	if _, err := bot.Request(tgbotapi.NewCallback(query.ID, answer)); err != nil {
+		log.Printf("Failed to answer callback query: %v", err)
+	}
 }
@@ -0,0 +1,143 @@
+package schedule
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dailyRe = regexp.MustCompile(`^([01]?\d|2[0-3]):([0-5]\d)$`)
+
+// Spec is a parsed schedule: a standard five-field cron expression
+// ("minute hour day-of-month month day-of-week") or a daily "HH:MM" time.
+type Spec struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+type field struct {
+	min, max int
+}
+
+var cronFields = []field{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, 0 and 7 are Sunday
+}
+
+// IsDailyTime reports whether s is a "HH:MM" time.
+func IsDailyTime(s string) bool {
+	return dailyRe.MatchString(s)
+}
+
+// Parse parses a cron expression or a daily "HH:MM" time.
+func Parse(expr string) (*Spec, error) {
+	expr = strings.TrimSpace(expr)
+	if m := dailyRe.FindStringSubmatch(expr); m != nil {
+		hour, _ := strconv.Atoi(m[1])
+		minute, _ := strconv.Atoi(m[2])
+		expr = fmt.Sprintf("%d %d * * *", minute, hour)
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("expected %d cron fields, got %d", len(cronFields), len(parts))
+	}
+
+	sets := make([][]bool, len(cronFields))
+	for i, part := range parts {
+		set, err := parseField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", part, err)
+		}
+		sets[i] = set
+	}
+	// Sunday can be written as 0 or 7
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+
+	return &Spec{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+func parseField(s string, f field) ([]bool, error) {
+	set := make([]bool, f.max+1)
+	for _, item := range strings.Split(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		from, to := f.min, f.max
+		if rangePart != "*" {
+			lo, hi, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = strconv.Atoi(lo); err != nil {
+				return nil, fmt.Errorf("invalid value %q", lo)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(hi); err != nil {
+					return nil, fmt.Errorf("invalid value %q", hi)
+				}
+			} else if hasStep {
+				to = f.max
+			}
+		}
+		if from < f.min || to > f.max || from > to {
+			return nil, fmt.Errorf("value out of range %d-%d", f.min, f.max)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// Matches reports whether the schedule fires at the minute of t. As in cron,
+// when both day fields are restricted a day matching either of them fires.
+func (s *Spec) Matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	domMatch, dowMatch := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dowMatch
+	case s.dowAny:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// Next returns the first time after t at which the schedule fires, or the
+// zero time if it does not fire within a year.
+func (s *Spec) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	for limit := next.AddDate(1, 0, 0); next.Before(limit); next = next.Add(time.Minute) {
+		if s.Matches(next) {
+			return next
+		}
+	}
+	return time.Time{}
+}
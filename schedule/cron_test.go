@@ -0,0 +1,116 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"0 9 * * 1-5", false},
+		{"*/15 * * * *", false},
+		{"5/10 * * * *", false},
+		{"0,30 8-18/2 1,15 * 0", false},
+		{"0 0 * * 7", false},
+		{"07:30", false},
+		{" 23:59 ", false},
+		{"", true},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"*/x * * * *", true},
+		{"a * * * *", true},
+		{"1- * * * *", true},
+		{"24:00", true},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIsDailyTime(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"07:30", true},
+		{"7:30", true},
+		{"23:59", true},
+		{"00:00", true},
+		{"24:00", false},
+		{"12:60", false},
+		{"12:5", false},
+		{"0 9 * * *", false},
+	}
+	for _, tt := range tests {
+		if got := IsDailyTime(tt.s); got != tt.want {
+			t.Errorf("IsDailyTime(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestSpecNext(t *testing.T) {
+	// 2026-03-04 is a Wednesday
+	from := time.Date(2026, 3, 4, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every minute", "* * * * *", from, time.Date(2026, 3, 4, 10, 21, 0, 0, time.UTC)},
+		{"daily time later today", "18:05", from, time.Date(2026, 3, 4, 18, 5, 0, 0, time.UTC)},
+		{"daily time tomorrow", "09:00", from, time.Date(2026, 3, 5, 9, 0, 0, 0, time.UTC)},
+		{"same minute is skipped", "20 10 * * *", from, time.Date(2026, 3, 5, 10, 20, 0, 0, time.UTC)},
+		{"step", "*/15 * * * *", from, time.Date(2026, 3, 4, 10, 30, 0, 0, time.UTC)},
+		{"step from value", "5/20 * * * *", from, time.Date(2026, 3, 4, 10, 25, 0, 0, time.UTC)},
+		{"list", "0 8,12 * * *", from, time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)},
+		{"weekdays on a friday evening", "0 9 * * 1-5", time.Date(2026, 3, 6, 20, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC)},
+		{"sunday as 0", "0 0 * * 0", from, time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 7", from, time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"day of month", "0 0 1 * *", from, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"month", "0 0 1 1 *", from, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"31st skips short months", "0 0 31 * *", from, time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)},
+		{"31st after march", "0 0 31 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 5, 31, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either of them matches
+		{"day of month or week", "0 0 15 * 5", from, time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{"never within a year", "0 0 30 2 *", from, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+			if got := spec.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpecNextTimezone(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	spec, err := Parse("09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2026, 3, 4, 7, 0, 0, 0, time.UTC) // 10:00 in loc
+	want := time.Date(2026, 3, 5, 9, 0, 0, 0, loc)
+	if got := spec.Next(from.In(loc)); !got.Equal(want) {
+		t.Errorf("Next = %v, want %v", got, want)
+	}
+}
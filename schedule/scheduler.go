@@ -0,0 +1,236 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxJobsPerUser limits how many scheduled prompts a user can have.
+const MaxJobsPerUser = 10
+
+var (
+	ErrTooManyJobs = errors.New("too many scheduled prompts")
+	ErrNeverRuns   = errors.New("the schedule does not run within a year")
+)
+
+// Job is a prompt that is sent to the model on a schedule on behalf of a user.
+type Job struct {
+	ID       string    `json:"id"`
+	UserID   int64     `json:"user_id"`
+	UserName string    `json:"user_name"`
+	ChatID   int64     `json:"chat_id"`
+	Spec     string    `json:"spec"`
+	Prompt   string    `json:"prompt"`
+	Created  time.Time `json:"created"`
+	LastRun  time.Time `json:"last_run,omitempty"`
+}
+
+// Scheduler keeps scheduled prompts in a JSON file and runs them when due.
+type Scheduler struct {
+	file  string
+	jobs  []*Job
+	specs map[string]*Spec
+	run   func(Job)
+	// location is the timezone the schedules are evaluated in
+	location *time.Location
+	mu       sync.Mutex
+}
+
+// NewScheduler loads the jobs stored in logsDir. Schedules are evaluated in
+// location. run is called in a separate goroutine for every job that is due.
+func NewScheduler(logsDir string, location *time.Location, run func(Job)) (*Scheduler, error) {
+	s := &Scheduler{
+		file:     filepath.Join(logsDir, "schedules.json"),
+		specs:    make(map[string]*Spec),
+		run:      run,
+		location: location,
+	}
+
+	data, err := os.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading schedules: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.jobs); err != nil {
+		return nil, fmt.Errorf("error unmarshalling schedules: %w", err)
+	}
+	for _, job := range s.jobs {
+		spec, err := Parse(job.Spec)
+		if err != nil {
+			log.Printf("Skipping schedule %s with invalid spec %q: %v", job.ID, job.Spec, err)
+			continue
+		}
+		s.specs[job.ID] = spec
+	}
+	return s, nil
+}
+
+// Start runs due jobs at the beginning of every minute.
+func (s *Scheduler) Start() {
+	go func() {
+		for {
+			now := time.Now()
+			time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+			s.tick(time.Now())
+		}
+	}()
+}
+
+func (s *Scheduler) tick(now time.Time) {
+	minute := now.In(s.location).Truncate(time.Minute)
+
+	s.mu.Lock()
+	var due []Job
+	for _, job := range s.jobs {
+		spec := s.specs[job.ID]
+		if spec == nil || !spec.Matches(minute) || !job.LastRun.Before(minute) {
+			continue
+		}
+		job.LastRun = minute
+		due = append(due, *job)
+	}
+	if len(due) > 0 {
+		s.save()
+	}
+	s.mu.Unlock()
+
+	for _, job := range due {
+		go s.run(job)
+	}
+}
+
+// Add validates the spec and stores a new job.
+func (s *Scheduler) Add(job Job) (*Job, error) {
+	spec, err := Parse(job.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Next(time.Now().In(s.location)).IsZero() {
+		return nil, ErrNeverRuns
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.userJobs(job.UserID)) >= MaxJobsPerUser {
+		return nil, ErrTooManyJobs
+	}
+
+	job.Created = time.Now()
+	job.ID = strconv.FormatInt(job.Created.UnixNano(), 36)
+	s.jobs = append(s.jobs, &job)
+	s.specs[job.ID] = spec
+	s.save()
+	return &job, nil
+}
+
+// List returns the jobs of a user in the order they were created.
+func (s *Scheduler) List(userID int64) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []Job
+	for _, job := range s.userJobs(userID) {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// NextRun returns the next time the job runs. The search can take a while,
+// so it is done outside of s.mu.
+func (s *Scheduler) NextRun(job Job) time.Time {
+	s.mu.Lock()
+	spec := s.specs[job.ID]
+	s.mu.Unlock()
+	if spec == nil {
+		return time.Time{}
+	}
+	return spec.Next(time.Now().In(s.location))
+}
+
+// Remove deletes the n-th job (1-based, as shown by List) of a user, or all
+// of the user's jobs if n is 0. It returns the number of removed jobs.
+func (s *Scheduler) Remove(userID int64, n int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userJobs := s.userJobs(userID)
+	if n < 0 || n > len(userJobs) {
+		return 0
+	}
+	remove := make(map[string]bool)
+	if n == 0 {
+		for _, job := range userJobs {
+			remove[job.ID] = true
+		}
+	} else {
+		remove[userJobs[n-1].ID] = true
+	}
+
+	jobs := s.jobs[:0]
+	for _, job := range s.jobs {
+		if remove[job.ID] {
+			delete(s.specs, job.ID)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	s.jobs = jobs
+	s.save()
+	return len(remove)
+}
+
+// userJobs returns the jobs of a user. s.mu must be held.
+func (s *Scheduler) userJobs(userID int64) []*Job {
+	var jobs []*Job
+	for _, job := range s.jobs {
+		if job.UserID == userID {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs
+}
+
+// save writes the jobs to disk. s.mu must be held.
+func (s *Scheduler) save() {
+	data, err := json.MarshalIndent(s.jobs, "", "  ")
+	if err != nil {
+		log.Printf("Error marshalling schedules: %v", err)
+		return
+	}
+	if err := os.WriteFile(s.file, data, 0644); err != nil {
+		log.Printf("Error writing schedules: %v", err)
+	}
+}
+
+// SplitArgs splits "/schedule" arguments into the schedule and the prompt: a
+// leading "HH:MM" time, or otherwise the first five words as a cron expression.
+func SplitArgs(args string) (string, string, bool) {
+	words := strings.Fields(args)
+	n := len(cronFields)
+	if len(words) > 0 && IsDailyTime(words[0]) {
+		n = 1
+	}
+	if len(words) <= n {
+		return "", "", false
+	}
+
+	// Cut the schedule words off the original text to keep line breaks in the prompt
+	prompt := strings.TrimSpace(args)
+	for _, word := range words[:n] {
+		prompt = strings.TrimSpace(strings.TrimPrefix(prompt, word))
+	}
+	return strings.Join(words[:n], " "), prompt, true
+}
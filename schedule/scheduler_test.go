@@ -0,0 +1,74 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchedulerAdd(t *testing.T) {
+	s, err := NewScheduler(t.TempDir(), time.UTC, func(Job) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec string
+		err  error
+	}{
+		{"09:00", nil},
+		{"0 9 * * 1-5", nil},
+		{"0 0 30 2 *", ErrNeverRuns},
+		{"0 0 31 4 *", ErrNeverRuns},
+	}
+	for _, tt := range tests {
+		if _, err := s.Add(Job{UserID: 1, Spec: tt.spec, Prompt: "hi"}); !errors.Is(err, tt.err) {
+			t.Errorf("Add(%q) error = %v, want %v", tt.spec, err, tt.err)
+		}
+	}
+	if _, err := s.Add(Job{UserID: 1, Spec: "60 * * * *"}); err == nil {
+		t.Error("Add accepted an invalid spec")
+	}
+}
+
+func TestSchedulerTickLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	ran := make(chan Job, 1)
+	s, err := NewScheduler(t.TempDir(), loc, func(job Job) { ran <- job })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add(Job{UserID: 1, Spec: "09:00", Prompt: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 09:00 UTC is 12:00 in the scheduler's timezone
+	s.tick(time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC))
+	select {
+	case job := <-ran:
+		t.Fatalf("job %s ran at 09:00 UTC", job.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	at := time.Date(2026, 3, 4, 6, 0, 0, 0, time.UTC)
+	s.tick(at)
+	select {
+	case job := <-ran:
+		if !job.LastRun.Equal(at) {
+			t.Errorf("LastRun = %v, want %v", job.LastRun, at)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job did not run at 09:00 in its timezone")
+	}
+
+	// A job runs once per minute
+	s.tick(at.Add(30 * time.Second))
+	select {
+	case <-ran:
+		t.Fatal("job ran twice in the same minute")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if next := s.NextRun(s.List(1)[0]); next.Location() != loc || next.Hour() != 9 || next.Minute() != 0 {
+		t.Errorf("NextRun = %v, want 09:00 in %v", next, loc)
+	}
+}
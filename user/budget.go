@@ -0,0 +1,217 @@
+package user
+
+import (
+	"errors"
+	"log"
+	"openrouter-bot/config"
+	"time"
+)
+
+// ErrInvalidPeriod is returned for an unknown budget period.
+var ErrInvalidPeriod = errors.New("invalid budget period")
+
+// BudgetOverride is a budget set by an admin for one user. It takes precedence
+// over the budget of the user's role.
+type BudgetOverride struct {
+	// Amount is the budget for the period, nil keeps the budget of the role.
+	Amount *float64 `json:"amount,omitempty"`
+	Period string   `json:"period,omitempty"`
+	// Grants are one-off credits, added to the budget of the period they
+	// were granted in.
+	Grants []Grant `json:"grants,omitempty"`
+}
+
+// Grant is a one-off credit granted on Day (YYYY-MM-DD).
+type Grant struct {
+	Amount float64 `json:"amount"`
+	Day    string  `json:"day"`
+}
+
+// BudgetOverride returns the budget set by an admin for the user, false if
+// there is none.
+func (ut *UsageTracker) BudgetOverride() (BudgetOverride, bool) {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+	if ut.Usage.Budget == nil {
+		return BudgetOverride{}, false
+	}
+	return *ut.Usage.Budget, true
+}
+
+// SetBudget sets the user's budget for the period. An empty period keeps the
+// period set before, or the configured budget period if there is none.
+func (ut *UsageTracker) SetBudget(amount float64, period string) error {
+	if period != "" && !config.ValidPeriod(period) {
+		return ErrInvalidPeriod
+	}
+	ut.updateBudget(func(b *BudgetOverride) {
+		b.Amount = &amount
+		if period != "" {
+			b.Period = period
+		}
+	})
+	return nil
+}
+
+// GrantBudget adds a one-off credit to the user's budget of the current period.
+func (ut *UsageTracker) GrantBudget(amount float64) {
+	ut.updateBudget(func(b *BudgetOverride) {
+		b.Grants = append(b.Grants, Grant{Amount: amount, Day: ut.today()})
+	})
+}
+
+// ResetBudget removes the budget set by an admin, the budget of the role applies again.
+func (ut *UsageTracker) ResetBudget() {
+	ut.UsageMu.Lock()
+	ut.Usage.Budget = nil
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save budget for user %s: %v", ut.UserID, err)
+	}
+}
+
+func (ut *UsageTracker) updateBudget(update func(*BudgetOverride)) {
+	ut.UsageMu.Lock()
+	if ut.Usage.Budget == nil {
+		ut.Usage.Budget = &BudgetOverride{}
+	}
+	update(ut.Usage.Budget)
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save budget for user %s: %v", ut.UserID, err)
+	}
+}
+
+// BudgetPeriod returns the budget period of the user: the one set by an admin,
+// otherwise the one from the config.
+func (ut *UsageTracker) BudgetPeriod(conf *config.Config) string {
+	if override, ok := ut.BudgetOverride(); ok && override.Period != "" {
+		return override.Period
+	}
+	return conf.BudgetPeriod
+}
+
+// Budget returns the budget of the user for the budget period: the one set by
+// an admin, otherwise the one of the user's role, plus the credits granted in
+// the period. false means the user has no limit.
+func (ut *UsageTracker) Budget(conf *config.Config) (float64, bool) {
+	override, ok := ut.BudgetOverride()
+
+	var budget float64
+	switch {
+	case ok && override.Amount != nil:
+		budget = *override.Amount
+	case ut.GetUserRole(conf) == "ADMIN":
+		return 0, false
+	case ut.GetUserRole(conf) == "USER":
+		budget = conf.UserBudget
+	default:
+		budget = conf.GuestBudget
+	}
+
+	period := ut.BudgetPeriod(conf)
+	now := time.Now()
+	for _, grant := range override.Grants {
+		if conf.InPeriod(grant.Day, period, now) {
+			budget += grant.Amount
+		}
+	}
+	return budget, true
+}
+
+// Remaining returns what is left of the user's budget for the budget period,
+// false means the user has no limit.
+func (ut *UsageTracker) Remaining(conf *config.Config) (float64, bool) {
+	budget, limited := ut.Budget(conf)
+	if !limited {
+		return 0, false
+	}
+	return budget - ut.GetCurrentCost(conf, ut.BudgetPeriod(conf)), true
+}
+
+// BudgetLow reports whether less than a fifth of the user's budget is left.
+func (ut *UsageTracker) BudgetLow(conf *config.Config) bool {
+	budget, limited := ut.Budget(conf)
+	if !limited {
+		return false
+	}
+	remaining, _ := ut.Remaining(conf)
+	return remaining < budget/5
+}
+
+// BudgetAlert is the highest budget threshold the user was notified about in
+// the period starting on Period.
+type BudgetAlert struct {
+	Period    string `json:"period"`
+	Threshold int64  `json:"threshold"`
+}
+
+// AlertPeriod identifies the budget period starting on start for the
+// notified thresholds. A rolling window moves every day, so it has one key.
+func AlertPeriod(period string, start time.Time) string {
+	if _, rolling := config.RollingDays(period); rolling {
+		return period
+	}
+	return start.Format("2006-01-02")
+}
+
+// BudgetStatus is the spending of a budget period when it crossed Threshold,
+// a percentage of the budget.
+type BudgetStatus struct {
+	Threshold int64
+	Spent     float64
+	Budget    float64
+	Period    string
+	// Reset is the end of the period, zero if it never resets
+	Reset time.Time
+}
+
+// Threshold returns the highest of thresholds (percentages of the budget)
+// reached by spent, 0 if none is.
+func Threshold(thresholds []int64, spent, budget float64) int64 {
+	var reached int64
+	for _, threshold := range thresholds {
+		if threshold > reached && spent >= budget*float64(threshold)/100 {
+			reached = threshold
+		}
+	}
+	return reached
+}
+
+// CrossedThreshold reports whether the user's spending reached a budget
+// threshold not notified yet in the current period, and records it. A
+// threshold that is no longer reached, after a grant for example, is
+// notified again when it is crossed again.
+func (ut *UsageTracker) CrossedThreshold(conf *config.Config) (BudgetStatus, bool) {
+	budget, limited := ut.Budget(conf)
+	if !limited || budget <= 0 || len(conf.BudgetThresholds) == 0 {
+		return BudgetStatus{}, false
+	}
+	period := ut.BudgetPeriod(conf)
+	start, end := conf.PeriodBounds(period, time.Now())
+	status := BudgetStatus{
+		Spent:  ut.GetCurrentCost(conf, period),
+		Budget: budget,
+		Period: period,
+		Reset:  end,
+	}
+	status.Threshold = Threshold(conf.BudgetThresholds, status.Spent, budget)
+	key := AlertPeriod(period, start)
+
+	ut.UsageMu.Lock()
+	alert := ut.Usage.BudgetAlert
+	if alert != nil && alert.Period == key && alert.Threshold == status.Threshold {
+		ut.UsageMu.Unlock()
+		return status, false
+	}
+	crossed := status.Threshold > 0 && (alert == nil || alert.Period != key || status.Threshold > alert.Threshold)
+	ut.Usage.BudgetAlert = &BudgetAlert{Period: key, Threshold: status.Threshold}
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save budget alert for user %s: %v", ut.UserID, err)
+	}
+	return status, crossed
+}
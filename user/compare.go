@@ -0,0 +1,65 @@
+package user
+
+import (
+	"strconv"
+	"time"
+)
+
+// maxPendingComparisons limits how many /compare results wait to be adopted.
+const maxPendingComparisons = 5
+
+// Comparison holds the answers of several models to the same prompt until
+// the user adopts one of them into the history.
+type Comparison struct {
+	Prompt  string
+	Answers []Message
+	Created time.Time
+}
+
+// AddComparison stores the comparison and returns its id.
+func (ut *UsageTracker) AddComparison(c *Comparison) string {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	if ut.History.comparisons == nil {
+		ut.History.comparisons = make(map[string]*Comparison)
+	}
+	// Drop the oldest comparison once the limit is reached
+	if len(ut.History.comparisons) >= maxPendingComparisons {
+		oldest := ""
+		for id, pending := range ut.History.comparisons {
+			if oldest == "" || pending.Created.Before(ut.History.comparisons[oldest].Created) {
+				oldest = id
+			}
+		}
+		delete(ut.History.comparisons, oldest)
+	}
+
+	c.Created = time.Now()
+	id := strconv.FormatInt(c.Created.UnixNano(), 36)
+	ut.History.comparisons[id] = c
+	return id
+}
+
+// AdoptComparison adds the prompt and the chosen answer of a comparison to
+// the history. It returns the adopted answer, or false if the comparison or
+// the answer no longer exists.
+func (ut *UsageTracker) AdoptComparison(id string, index int) (Message, bool) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	c, ok := ut.History.comparisons[id]
+	if !ok || index < 0 || index >= len(c.Answers) {
+		return Message{}, false
+	}
+	delete(ut.History.comparisons, id)
+
+	prompt := Message{Role: "user", Content: c.Prompt, Time: c.Created}
+	answer := c.Answers[index]
+	for _, msg := range []Message{prompt, answer} {
+		ut.History.messages = append(ut.History.messages, msg)
+		ut.recordMessage(msg)
+	}
+	ut.LastMessageTime = time.Now()
+	return answer, true
+}
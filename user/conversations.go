@@ -0,0 +1,189 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// maxStoredConversations limits how many past conversations are kept per user.
+const maxStoredConversations = 100
+
+// conversationSaveDelay is how long changes of the conversations are collected
+// before they are written.
+const conversationSaveDelay = 2 * time.Second
+
+// Conversation is the full transcript of a dialog. Unlike History, which only
+// holds the messages sent to the model, it is never trimmed.
+type Conversation struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name,omitempty"`
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
+	Messages     []Message `json:"messages"`
+}
+
+type conversationStore struct {
+	Current       string          `json:"current"`
+	Conversations []*Conversation `json:"conversations"`
+}
+
+// Title returns the conversation name or the beginning of its first user message.
+func (c *Conversation) Title() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	for _, msg := range c.Messages {
+		if msg.Role == "user" {
+			runes := []rune(msg.Content)
+			if len(runes) > 40 {
+				return string(runes[:40]) + "…"
+			}
+			return msg.Content
+		}
+	}
+	return c.Created.Format("2006-01-02 15:04")
+}
+
+func newConversationID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// currentConversation returns the conversation new messages are recorded to,
+// starting a new one if needed. History.mu must be held.
+func (ut *UsageTracker) currentConversation() *Conversation {
+	if ut.History.current == nil {
+		now := time.Now()
+		ut.History.current = &Conversation{
+			ID:           newConversationID(),
+			SystemPrompt: ut.SystemPrompt,
+			Created:      now,
+			Updated:      now,
+		}
+		ut.History.conversations = append(ut.History.conversations, ut.History.current)
+		if len(ut.History.conversations) > maxStoredConversations {
+			ut.History.conversations = ut.History.conversations[len(ut.History.conversations)-maxStoredConversations:]
+		}
+	}
+	return ut.History.current
+}
+
+// recordMessage adds a message to the current conversation. History.mu must be held.
+func (ut *UsageTracker) recordMessage(msg Message) {
+	conv := ut.currentConversation()
+	conv.Messages = append(conv.Messages, msg)
+	conv.Updated = msg.Time
+	ut.saveConversations()
+}
+
+// ImportConversation stores the imported messages as the current conversation
+// and loads the last maxMessages of them into the history. It returns the
+// number of messages loaded into the history.
+func (ut *UsageTracker) ImportConversation(name, systemPrompt string, messages []Message, maxMessages int) int {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	now := time.Now()
+	for i := range messages {
+		if messages[i].Time.IsZero() {
+			messages[i].Time = now
+		}
+	}
+	if systemPrompt != "" {
+		ut.SystemPrompt = systemPrompt
+	}
+
+	ut.History.current = nil
+	conv := ut.currentConversation()
+	conv.Name = name
+	conv.Messages = messages
+
+	ut.History.messages = lastMessages(messages, maxMessages)
+	ut.LastMessageTime = now
+	ut.saveConversations()
+	return len(ut.History.messages)
+}
+
+func lastMessages(messages []Message, n int) []Message {
+	if len(messages) > n {
+		messages = messages[len(messages)-n:]
+	}
+	result := make([]Message, len(messages))
+	copy(result, messages)
+	return result
+}
+
+func (ut *UsageTracker) conversationsFile() string {
+	return filepath.Join(ut.LogsDir, "conversations", ut.UserID+".json")
+}
+
+// saveConversations schedules writing the conversation store to disk, so that
+// a burst of messages is written once. History.mu must be held.
+func (ut *UsageTracker) saveConversations() {
+	if ut.History.saveTimer == nil {
+		ut.History.saveTimer = time.AfterFunc(conversationSaveDelay, ut.writeConversations)
+	}
+}
+
+// writeConversations writes the conversation store to disk. The store is
+// marshalled under History.mu and written outside of it.
+func (ut *UsageTracker) writeConversations() {
+	ut.History.mu.Lock()
+	ut.History.saveTimer = nil
+	store := conversationStore{Conversations: ut.History.conversations}
+	if ut.History.current != nil {
+		store.Current = ut.History.current.ID
+	}
+	data, err := json.MarshalIndent(store, "", "  ")
+	ut.History.mu.Unlock()
+	if err != nil {
+		log.Printf("Error marshalling conversations for user %s: %v", ut.UserID, err)
+		return
+	}
+
+	ut.History.fileMu.Lock()
+	defer ut.History.fileMu.Unlock()
+	filename := ut.conversationsFile()
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Printf("Error creating conversations directory: %v", err)
+		return
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		log.Printf("Error writing conversations for user %s: %v", ut.UserID, err)
+	}
+}
+
+// loadConversations restores stored conversations and the history of the
+// current one. The last update time is restored as well, so an expired
+// conversation is still cleared by CheckHistory.
+func (ut *UsageTracker) loadConversations() error {
+	data, err := os.ReadFile(ut.conversationsFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading conversations: %w", err)
+	}
+
+	var store conversationStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return fmt.Errorf("error unmarshalling conversations: %w", err)
+	}
+
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	ut.History.conversations = store.Conversations
+	for _, conv := range store.Conversations {
+		if conv.ID == store.Current {
+			ut.History.current = conv
+			ut.History.messages = lastMessages(conv.Messages, len(conv.Messages))
+			ut.LastMessageTime = conv.Updated
+		}
+	}
+	return nil
+}
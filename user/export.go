@@ -0,0 +1,124 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+)
+
+// Export formats supported by /export.
+const (
+	ExportMarkdown = "md"
+	ExportJSON     = "json"
+	ExportHTML     = "html"
+)
+
+// ConversationExport is the JSON export format, it is also accepted by /import.
+type ConversationExport struct {
+	UserName     string    `json:"user_name,omitempty"`
+	ExportedAt   time.Time `json:"exported_at"`
+	SystemPrompt string    `json:"system_prompt"`
+	TotalCost    float64   `json:"total_cost"`
+	Messages     []Message `json:"messages"`
+}
+
+// Export renders the current conversation in the given format and returns
+// a file name for it along with the file content.
+func (ut *UsageTracker) Export(format string) (string, []byte, error) {
+	ut.History.mu.Lock()
+	messages := make([]Message, len(ut.History.messages))
+	copy(messages, ut.History.messages)
+	ut.History.mu.Unlock()
+
+	export := ConversationExport{
+		UserName:     ut.UserName,
+		ExportedAt:   time.Now(),
+		SystemPrompt: ut.SystemPrompt,
+		Messages:     messages,
+	}
+	for _, msg := range messages {
+		export.TotalCost += msg.Cost
+	}
+
+	name := "conversation_" + export.ExportedAt.Format("20060102_150405") + "." + format
+	switch format {
+	case ExportJSON:
+		data, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			return "", nil, fmt.Errorf("error marshalling conversation: %w", err)
+		}
+		return name, data, nil
+	case ExportMarkdown:
+		return name, []byte(exportMarkdown(export)), nil
+	case ExportHTML:
+		return name, []byte(exportHTML(export)), nil
+	default:
+		return "", nil, fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+// turnHeader describes a message: role, model, time and cost.
+func turnHeader(msg Message) string {
+	role := msg.Role
+	if role != "" {
+		role = strings.ToUpper(role[:1]) + role[1:]
+	}
+	parts := []string{role}
+	if msg.Model != "" {
+		parts = append(parts, msg.Model)
+	}
+	if !msg.Time.IsZero() {
+		parts = append(parts, msg.Time.Format("2006-01-02 15:04:05"))
+	}
+	if msg.Cost > 0 {
+		parts = append(parts, fmt.Sprintf("$%.6f", msg.Cost))
+	}
+	return strings.Join(parts, " · ")
+}
+
+func exportMarkdown(export ConversationExport) string {
+	var b strings.Builder
+	b.WriteString("# Conversation\n\n")
+	fmt.Fprintf(&b, "- Exported: %s\n", export.ExportedAt.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "- Messages: %d\n", len(export.Messages))
+	fmt.Fprintf(&b, "- Total cost: $%.6f\n\n", export.TotalCost)
+	b.WriteString("## System prompt\n\n" + export.SystemPrompt + "\n\n")
+	for _, msg := range export.Messages {
+		b.WriteString("## " + turnHeader(msg) + "\n\n" + msg.Content + "\n\n")
+	}
+	return b.String()
+}
+
+func exportHTML(export ConversationExport) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Conversation</title>
+<style>
+body { font-family: sans-serif; max-width: 860px; margin: 2em auto; line-height: 1.5; }
+.turn { border-radius: 8px; padding: 0.5em 1em; margin: 1em 0; }
+.system { background: #f4f4f4; }
+.user { background: #e8f0fe; }
+.assistant { background: #eef7ee; }
+.header { color: #666; font-size: 0.85em; }
+.content { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Conversation</h1>
+`)
+	fmt.Fprintf(&b, "<p>Exported: %s<br>Messages: %d<br>Total cost: $%.6f</p>\n",
+		export.ExportedAt.Format("2006-01-02 15:04:05"), len(export.Messages), export.TotalCost)
+	fmt.Fprintf(&b, "<div class=\"turn system\"><div class=\"header\">System prompt</div><div class=\"content\">%s</div></div>\n",
+		html.EscapeString(export.SystemPrompt))
+	for _, msg := range export.Messages {
+		fmt.Fprintf(&b, "<div class=\"turn %s\"><div class=\"header\">%s</div><div class=\"content\">%s</div></div>\n",
+			html.EscapeString(msg.Role), html.EscapeString(turnHeader(msg)), html.EscapeString(msg.Content))
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
@@ -1,11 +1,59 @@
 package user
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 func (ut *UsageTracker) AddMessage(role, content string) {
 	ut.History.mu.Lock()
 	defer ut.History.mu.Unlock()
-	ut.History.messages = append(ut.History.messages, Message{Role: role, Content: content})
+	msg := Message{Role: role, Content: content, Time: time.Now()}
+	ut.History.messages = append(ut.History.messages, msg)
+	ut.recordMessage(msg)
+}
+
+// AddResponse adds an assistant message along with the model that produced it.
+func (ut *UsageTracker) AddResponse(content, model, generationID string) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	msg := Message{
+		Role:         "assistant",
+		Content:      content,
+		Model:        model,
+		Time:         time.Now(),
+		GenerationID: generationID,
+	}
+	ut.History.messages = append(ut.History.messages, msg)
+	ut.recordMessage(msg)
+}
+
+// SetMessageUsage records the cost and the tokens of the generation on the
+// message it produced.
+func (ut *UsageTracker) SetMessageUsage(generationID string, cost float64, tokens int) {
+	if generationID == "" {
+		return
+	}
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+	addMessageUsage(ut.History.messages, generationID, cost, tokens)
+	for _, conv := range ut.History.conversations {
+		if addMessageUsage(conv.Messages, generationID, cost, tokens) {
+			ut.saveConversations()
+			return
+		}
+	}
+}
+
+func addMessageUsage(messages []Message, generationID string, cost float64, tokens int) bool {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].GenerationID == generationID {
+			messages[i].Cost += cost
+			messages[i].Tokens += tokens
+			return true
+		}
+	}
+	return false
 }
 
 func (ut *UsageTracker) GetMessages() []Message {
@@ -14,10 +62,13 @@ func (ut *UsageTracker) GetMessages() []Message {
 	return ut.History.messages
 }
 
+// ClearHistory clears the history, the next message starts a new conversation.
 func (ut *UsageTracker) ClearHistory() {
 	ut.History.mu.Lock()
 	defer ut.History.mu.Unlock()
 	ut.History.messages = []Message{}
+	ut.History.current = nil
+	ut.saveConversations()
 }
 
 func (ut *UsageTracker) CheckHistory(maxMessages int, maxTime int) {
@@ -30,6 +81,7 @@ func (ut *UsageTracker) CheckHistory(maxMessages int, maxTime int) {
 	if ut.LastMessageTime.Before(time.Now().Add(-time.Duration(maxTime) * time.Minute)) {
 		// Remove messages older than the maximum time limit
 		ut.History.messages = make([]Message, 0)
+		ut.History.current = nil
 	}
 
 	if len(ut.History.messages) > maxMessages {
@@ -37,3 +89,43 @@ func (ut *UsageTracker) CheckHistory(maxMessages int, maxTime int) {
 		ut.History.messages = ut.History.messages[len(ut.History.messages)-maxMessages:]
 	}
 }
+
+// FitContext drops the oldest messages of the history until the history and
+// the reserved tokens (system prompt, pinned messages, the new message) fit
+// into maxTokens. At least the last message is always kept.
+func (ut *UsageTracker) FitContext(maxTokens, reserved int) {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	ut.History.messages = fitMessages(ut.History.messages, maxTokens, reserved)
+}
+
+// ContextPreview returns the history the next message would be sent with,
+// trimmed as by CheckHistory and FitContext, without changing the history.
+func (ut *UsageTracker) ContextPreview(maxMessages, maxTime, maxTokens, reserved int) []Message {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	messages := ut.History.messages
+	if !ut.LastMessageTime.IsZero() && ut.LastMessageTime.Before(time.Now().Add(-time.Duration(maxTime)*time.Minute)) {
+		return nil
+	}
+	if len(messages) > maxMessages {
+		messages = messages[len(messages)-maxMessages:]
+	}
+	return slices.Clone(fitMessages(messages, maxTokens, reserved))
+}
+
+// fitMessages returns the last messages that fit into maxTokens together with
+// the reserved tokens, at least the last message.
+func fitMessages(messages []Message, maxTokens, reserved int) []Message {
+	total := reserved
+	for _, msg := range messages {
+		total += EstimateTokens(msg.Content)
+	}
+	for total > maxTokens && len(messages) > 1 {
+		total -= EstimateTokens(messages[0].Content)
+		messages = messages[1:]
+	}
+	return messages
+}
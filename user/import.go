@@ -0,0 +1,140 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrImportFormat   = errors.New("unsupported transcript format")
+	ErrImportEmpty    = errors.New("transcript has no messages")
+	ErrImportRole     = errors.New("transcript has an unknown role")
+	ErrImportTooLarge = errors.New("transcript exceeds the token limit")
+)
+
+var (
+	// "## User · gpt-4o · 2025-01-01 10:00:00" as written by /export, or "### Assistant"
+	transcriptHeadingRe = regexp.MustCompile(`^#{1,6}\s+([\p{L} ]+?)\s*(?:·.*)?$`)
+	// "User: text", "**Assistant:** text"
+	transcriptLabelRe = regexp.MustCompile(`^\*{0,2}([\p{L} ]{2,20}?)\*{0,2}:\*{0,2}\s*(.*)$`)
+)
+
+var transcriptRoles = map[string]string{
+	"user":          "user",
+	"human":         "user",
+	"you":           "user",
+	"assistant":     "assistant",
+	"ai":            "assistant",
+	"bot":           "assistant",
+	"model":         "assistant",
+	"chatgpt":       "assistant",
+	"system":        "system",
+	"system prompt": "system",
+}
+
+// ParseTranscript reads a conversation exported by /export as JSON, or a
+// Markdown transcript where turns start with a role heading ("## User") or
+// a role label ("User: ..."). The total size is checked against maxTokens.
+func ParseTranscript(fileName string, data []byte, maxTokens int) (*ConversationExport, error) {
+	var conv *ConversationExport
+	var err error
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		conv, err = parseJSONTranscript(data)
+	case ".md", ".markdown", ".txt":
+		conv, err = parseMarkdownTranscript(string(data))
+	default:
+		return nil, ErrImportFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conv.Messages) == 0 {
+		return nil, ErrImportEmpty
+	}
+	tokens := EstimateTokens(conv.SystemPrompt)
+	for _, msg := range conv.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return nil, fmt.Errorf("%w: %q", ErrImportRole, msg.Role)
+		}
+		tokens += EstimateTokens(msg.Content)
+	}
+	if maxTokens > 0 && tokens > maxTokens {
+		return nil, fmt.Errorf("%w: ~%d > %d", ErrImportTooLarge, tokens, maxTokens)
+	}
+	return conv, nil
+}
+
+func parseJSONTranscript(data []byte) (*ConversationExport, error) {
+	var conv ConversationExport
+	if err := json.Unmarshal(data, &conv); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImportFormat, err)
+	}
+
+	// A system message in the list is treated as the system prompt
+	messages := conv.Messages[:0]
+	for _, msg := range conv.Messages {
+		msg.Role = strings.ToLower(strings.TrimSpace(msg.Role))
+		if msg.Role == "system" {
+			conv.SystemPrompt = msg.Content
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	conv.Messages = messages
+	return &conv, nil
+}
+
+func parseMarkdownTranscript(text string) (*ConversationExport, error) {
+	conv := &ConversationExport{}
+	var role string
+	var content []string
+
+	flush := func() {
+		body := strings.TrimSpace(strings.Join(content, "\n"))
+		switch {
+		case role == "system":
+			conv.SystemPrompt = body
+		case role != "" && body != "":
+			conv.Messages = append(conv.Messages, Message{Role: role, Content: body})
+		}
+		content = nil
+	}
+
+	inCode := false
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+		}
+		if !inCode {
+			if m := transcriptHeadingRe.FindStringSubmatch(line); m != nil {
+				if r, ok := transcriptRoles[strings.ToLower(m[1])]; ok {
+					flush()
+					role = r
+					continue
+				}
+			}
+			if m := transcriptLabelRe.FindStringSubmatch(line); m != nil {
+				if r, ok := transcriptRoles[strings.ToLower(strings.TrimSpace(m[1]))]; ok {
+					flush()
+					role = r
+					content = append(content, m[2])
+					continue
+				}
+			}
+		}
+		content = append(content, line)
+	}
+	flush()
+
+	if len(conv.Messages) == 0 && conv.SystemPrompt == "" {
+		return nil, ErrImportFormat
+	}
+	return conv, nil
+}
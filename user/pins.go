@@ -0,0 +1,68 @@
+package user
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// MaxPins limits how many messages a user can pin.
+const MaxPins = 20
+
+var (
+	ErrTooManyPins = errors.New("too many pinned messages")
+	ErrPinTooLarge = errors.New("pinned messages exceed the token budget")
+)
+
+// Pins returns a copy of the pinned messages of the user.
+func (ut *UsageTracker) Pins() []string {
+	return slices.Clone(ut.GetSettings().Pins)
+}
+
+// AddPin pins a text, the pinned messages together may take at most
+// maxTokens tokens.
+func (ut *UsageTracker) AddPin(text string, maxTokens int) error {
+	text = strings.TrimSpace(text)
+	pins := ut.Pins()
+	if len(pins) >= MaxPins {
+		return ErrTooManyPins
+	}
+	if PinnedTokens(append(pins, text)) > maxTokens {
+		return ErrPinTooLarge
+	}
+	ut.UpdateSettings(func(s *UserSettings) {
+		s.Pins = append(s.Pins, text)
+	})
+	return nil
+}
+
+// RemovePin removes the n-th pinned message (1-based), or all of them if n
+// is 0. It returns the number of removed messages.
+func (ut *UsageTracker) RemovePin(n int) int {
+	removed := 0
+	ut.UpdateSettings(func(s *UserSettings) {
+		switch {
+		case n == 0:
+			removed = len(s.Pins)
+			s.Pins = nil
+		case n > 0 && n <= len(s.Pins):
+			removed = 1
+			s.Pins = append(s.Pins[:n-1:n-1], s.Pins[n:]...)
+		}
+	})
+	return removed
+}
+
+// PinnedContext joins the pinned messages into the text sent to the model
+// after the system prompt.
+func PinnedContext(pins []string) string {
+	if len(pins) == 0 {
+		return ""
+	}
+	return "Pinned context, always take it into account:\n- " + strings.Join(pins, "\n- ")
+}
+
+// PinnedTokens estimates the tokens the pinned messages take in the context.
+func PinnedTokens(pins []string) int {
+	return EstimateTokens(PinnedContext(pins))
+}
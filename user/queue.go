@@ -0,0 +1,93 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("request queue is full")
+
+// requestQueue runs the requests of a user one at a time, in the order they
+// were received, so that turns never read or write the history concurrently.
+type requestQueue struct {
+	mu      sync.Mutex
+	pending []queuedRequest
+	running bool
+	cancel  context.CancelFunc
+}
+
+type queuedRequest struct {
+	run     func(ctx context.Context)
+	dropped func()
+}
+
+// Enqueue adds a request to the user's queue. run is called with a context
+// that is cancelled by Stop, dropped is called instead of run if the request
+// is removed from the queue before it starts. At most maxDepth requests may
+// wait. It returns the number of requests ahead of this one.
+func (ut *UsageTracker) Enqueue(run func(ctx context.Context), dropped func(), maxDepth int) (int, error) {
+	q := &ut.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	req := queuedRequest{run: run, dropped: dropped}
+	if !q.running {
+		q.running = true
+		go ut.processQueue(q.start(), req)
+		return 0, nil
+	}
+	if len(q.pending) >= maxDepth {
+		return 0, ErrQueueFull
+	}
+	q.pending = append(q.pending, req)
+	return len(q.pending), nil
+}
+
+// start returns the context of the request about to run. q.mu must be held.
+func (q *requestQueue) start() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	return ctx
+}
+
+func (ut *UsageTracker) processQueue(ctx context.Context, req queuedRequest) {
+	q := &ut.queue
+	for {
+		req.run(ctx)
+
+		q.mu.Lock()
+		q.cancel()
+		q.cancel = nil
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		req = q.pending[0]
+		q.pending = q.pending[1:]
+		ctx = q.start()
+		q.mu.Unlock()
+	}
+}
+
+// Stop cancels the running request and drops the waiting ones. It reports
+// whether a request was running and how many were dropped.
+func (ut *UsageTracker) Stop() (bool, int) {
+	q := &ut.queue
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = nil
+	active := q.cancel != nil
+	if active {
+		q.cancel()
+	}
+	q.mu.Unlock()
+
+	for _, req := range pending {
+		if req.dropped != nil {
+			req.dropped()
+		}
+	}
+	return active, len(pending)
+}
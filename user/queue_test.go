@@ -0,0 +1,115 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEnqueueOrder(t *testing.T) {
+	ut := &UsageTracker{}
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		_, err := ut.Enqueue(func(ctx context.Context) {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}, nil, 10)
+		if err != nil {
+			t.Fatalf("Enqueue %d: %v", i, err)
+		}
+	}
+	wg.Wait()
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("requests ran in order %v", order)
+		}
+	}
+}
+
+func TestEnqueueDepth(t *testing.T) {
+	ut := &UsageTracker{}
+	release := make(chan struct{})
+	defer close(release)
+	block := func(ctx context.Context) { <-release }
+
+	tests := []struct {
+		ahead int
+		err   error
+	}{
+		{0, nil},
+		{1, nil},
+		{2, nil},
+		{0, ErrQueueFull},
+	}
+	for i, tt := range tests {
+		ahead, err := ut.Enqueue(block, nil, 2)
+		if ahead != tt.ahead || !errors.Is(err, tt.err) {
+			t.Errorf("Enqueue %d = %d, %v, want %d, %v", i, ahead, err, tt.ahead, tt.err)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	ut := &UsageTracker{}
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	ut.Enqueue(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+	}, nil, 5)
+	<-started
+
+	var mu sync.Mutex
+	ran, dropped := 0, 0
+	for i := 0; i < 2; i++ {
+		ut.Enqueue(func(ctx context.Context) {
+			mu.Lock()
+			ran++
+			mu.Unlock()
+		}, func() {
+			mu.Lock()
+			dropped++
+			mu.Unlock()
+		}, 5)
+	}
+
+	active, n := ut.Stop()
+	if !active || n != 2 {
+		t.Errorf("Stop() = %v, %d, want true, 2", active, n)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the running request was not cancelled")
+	}
+
+	// The queue still works after Stop
+	done := make(chan struct{})
+	ut.Enqueue(func(ctx context.Context) { close(done) }, nil, 5)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a request queued after Stop did not run")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if ran != 0 || dropped != 2 {
+		t.Errorf("ran %d and dropped %d requests, want 0 and 2", ran, dropped)
+	}
+}
+
+func TestStopIdle(t *testing.T) {
+	ut := &UsageTracker{}
+	if active, n := ut.Stop(); active || n != 0 {
+		t.Errorf("Stop() = %v, %d, want false, 0", active, n)
+	}
+}
@@ -0,0 +1,140 @@
+package user
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const snippetRadius = 60
+
+// SearchResult is a conversation matching a search query.
+type SearchResult struct {
+	ConversationID string
+	Title          string
+	Snippet        string
+	Updated        time.Time
+	Current        bool
+	score          float64
+}
+
+// Search looks for the query in all stored conversations of the user and
+// returns up to limit conversations, best matches first. A conversation is
+// ranked by its best matching message: matched query terms and their count,
+// an exact phrase bonus, and a boost for recent conversations.
+func (ut *UsageTracker) Search(query string, limit int) []SearchResult {
+	phrase := strings.ToLower(strings.TrimSpace(query))
+	terms := searchTerms(phrase)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	var results []SearchResult
+	for _, conv := range ut.History.conversations {
+		best := 0.0
+		snippet := ""
+		for _, msg := range conv.Messages {
+			content := strings.ToLower(msg.Content)
+			score := matchScore(content, phrase, terms)
+			if score > best {
+				best = score
+				snippet = makeSnippet(msg.Content, content, phrase, terms)
+			}
+		}
+		if best == 0 {
+			continue
+		}
+
+		days := time.Since(conv.Updated).Hours() / 24
+		results = append(results, SearchResult{
+			ConversationID: conv.ID,
+			Title:          conv.Title(),
+			Snippet:        snippet,
+			Updated:        conv.Updated,
+			Current:        conv == ut.History.current,
+			score:          best * (1 + 1/(1+days/7)),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchScore is zero unless every term occurs in the content.
+func matchScore(content, phrase string, terms []string) float64 {
+	score := 0.0
+	for _, term := range terms {
+		count := strings.Count(content, term)
+		if count == 0 {
+			return 0
+		}
+		score += 1 + math.Log(float64(count))
+	}
+	if len(terms) > 1 && strings.Contains(content, phrase) {
+		score *= 2
+	}
+	return score
+}
+
+// makeSnippet cuts the text around the first occurrence of the phrase, or of
+// the first term if the phrase does not occur as a whole.
+func makeSnippet(text, lower, phrase string, terms []string) string {
+	pos := strings.Index(lower, phrase)
+	if pos < 0 {
+		pos = strings.Index(lower, terms[0])
+	}
+
+	// ToLower may change byte lengths, so the position is mapped through runes
+	runes := []rune(text)
+	center := len([]rune(lower[:max(pos, 0)]))
+	from := max(center-snippetRadius, 0)
+	to := min(center+snippetRadius, len(runes))
+
+	snippet := strings.Join(strings.Fields(string(runes[from:to])), " ")
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// RestoreConversation makes a stored conversation current again and loads its
+// last maxMessages into the history. It returns the conversation or nil if it
+// does not exist.
+func (ut *UsageTracker) RestoreConversation(id string, maxMessages int) *Conversation {
+	ut.History.mu.Lock()
+	defer ut.History.mu.Unlock()
+
+	for _, conv := range ut.History.conversations {
+		if conv.ID != id {
+			continue
+		}
+		ut.History.current = conv
+		ut.History.messages = lastMessages(conv.Messages, maxMessages)
+		if conv.SystemPrompt != "" {
+			ut.SystemPrompt = conv.SystemPrompt
+		}
+		ut.LastMessageTime = time.Now()
+		ut.saveConversations()
+		return conv
+	}
+	return nil
+}
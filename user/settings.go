@@ -0,0 +1,87 @@
+package user
+
+import (
+	"log"
+	"openrouter-bot/config"
+	"openrouter-bot/lang"
+	"strings"
+)
+
+// File delivery modes for long answers.
+const (
+	FileModeOff  = "off"
+	FileModeLong = "long"
+	FileModeCode = "code"
+)
+
+// GetSettings returns a copy of the user settings.
+func (ut *UsageTracker) GetSettings() UserSettings {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+	return ut.Usage.Settings
+}
+
+// UpdateSettings applies update to the user settings and saves them.
+func (ut *UsageTracker) UpdateSettings(update func(*UserSettings)) {
+	ut.UsageMu.Lock()
+	update(&ut.Usage.Settings)
+	ut.UsageMu.Unlock()
+
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save settings for user %s: %v", ut.UserID, err)
+	}
+}
+
+// FileDelivery returns the file delivery mode and the threshold in characters
+// above which answers are sent as files.
+func (ut *UsageTracker) FileDelivery(conf *config.Config) (string, int) {
+	settings := ut.GetSettings()
+	mode, threshold := settings.FileMode, settings.FileThreshold
+	if mode == "" {
+		mode = conf.FileMode
+	}
+	if threshold <= 0 {
+		threshold = conf.FileThreshold
+	}
+	return mode, threshold
+}
+
+// Lang returns the interface language of the user: the one chosen with /lang,
+// otherwise the Telegram client language if there is a translation for it,
+// otherwise the language from the config.
+func (ut *UsageTracker) Lang(conf *config.Config) string {
+	if language := ut.GetSettings().Lang; language != "" {
+		return language
+	}
+	ut.UsageMu.Lock()
+	code := strings.ToUpper(ut.LanguageCode)
+	ut.UsageMu.Unlock()
+	if code != "" && lang.Has(code) {
+		return code
+	}
+	return strings.ToUpper(conf.Lang)
+}
+
+// SetLanguageCode records the language of the user's Telegram client.
+func (ut *UsageTracker) SetLanguageCode(code string) {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
+	ut.LanguageCode = code
+}
+
+// Model returns the model chosen by the user with /set_model, or the model
+// from the config.
+func (ut *UsageTracker) Model(conf *config.Config) string {
+	if model := ut.GetSettings().Model; model != "" {
+		return model
+	}
+	return conf.Model.ModelName
+}
+
+// SetModel sets the model of the user, an empty name returns to the model
+// from the config.
+func (ut *UsageTracker) SetModel(model string) {
+	ut.UpdateSettings(func(s *UserSettings) {
+		s.Model = model
+	})
+}
@@ -0,0 +1,9 @@
+package user
+
+import "unicode/utf8"
+
+// EstimateTokens roughly estimates the number of tokens in a text without a
+// tokenizer, assuming about four characters per token.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
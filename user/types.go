@@ -3,36 +3,61 @@ package user
 import (
 	"sync"
 	"time"
-
-	"github.com/sashabaranov/go-openai"
 )
 
 type UsageTracker struct {
-	UserID          string
-	UserName        string
-	LogsDir         string
-	SystemPrompt    string
+	UserID       string
+	UserName     string
+	LogsDir      string
+	SystemPrompt string
+	// LanguageCode is the language of the Telegram client, guarded by UsageMu
+	LanguageCode    string
 	LastMessageTime time.Time
-	CurrentStream   *openai.ChatCompletionStream
 	Usage           *UserUsage
 	History         History
-	UsageMu         sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к Usage
-	FileMu          sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к файлу
+	queue           requestQueue
+	// location is the timezone of the usage days
+	location *time.Location
+	UsageMu  sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к Usage
+	FileMu   sync.Mutex `json:"-"` // Мьютекс для синхронизации доступа к файлу
 }
 
 type Message struct {
-	Role    string
-	Content string
+	Role         string    `json:"role"`
+	Content      string    `json:"content"`
+	Model        string    `json:"model,omitempty"`
+	Time         time.Time `json:"time"`
+	Cost         float64   `json:"cost,omitempty"`
+	Tokens       int       `json:"tokens,omitempty"`
+	GenerationID string    `json:"generation_id,omitempty"`
 }
 
 type History struct {
-	messages []Message
-	mu       sync.Mutex
+	messages      []Message
+	conversations []*Conversation
+	current       *Conversation
+	comparisons   map[string]*Comparison
+	saveTimer     *time.Timer
+	mu            sync.Mutex
+	fileMu        sync.Mutex
 }
 
 type UserUsage struct {
-	UserName     string    `json:"user_name"`
-	UsageHistory UsageHist `json:"usage_history"`
+	UserName     string       `json:"user_name"`
+	UsageHistory UsageHist    `json:"usage_history"`
+	Settings     UserSettings `json:"settings"`
+	// Budget is set by an admin with /set_budget
+	Budget      *BudgetOverride `json:"budget,omitempty"`
+	BudgetAlert *BudgetAlert    `json:"budget_alert,omitempty"`
+}
+
+// UserSettings are per-user preferences, zero values mean "use the config default".
+type UserSettings struct {
+	Lang          string   `json:"lang,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	FileMode      string   `json:"file_mode,omitempty"`
+	FileThreshold int      `json:"file_threshold,omitempty"`
+	Pins          []string `json:"pins,omitempty"`
 }
 
 type Cost struct {
@@ -43,7 +68,8 @@ type Cost struct {
 }
 
 type UsageHist struct {
-	ChatCost map[string]float64 `json:"chat_cost"`
+	ChatCost   map[string]float64 `json:"chat_cost"`
+	ChatTokens map[string]int     `json:"chat_tokens,omitempty"`
 }
 
 type GenerationResponse struct {
@@ -4,11 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"openrouter-bot/config"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 )
 
@@ -27,47 +25,36 @@ func NewUsageTracker(userID, userName, logsDir string, conf *config.Config) *Usa
 			messages: make([]Message, 0),
 		},
 		SystemPrompt: conf.SystemPrompt,
+		location:     conf.Location(),
 	}
 
 	err := usageTracker.loadUsage()
 	if err != nil {
 		log.Printf("Error loading usage for user %s: %v", userID, err)
 	}
+	if err := usageTracker.loadConversations(); err != nil {
+		log.Printf("Error loading conversations for user %s: %v", userID, err)
+	}
 
 	return usageTracker
 }
 
+// HaveAccess reports whether the user has budget left, admins have no limit
+// unless an admin set a budget for them.
 func (ut *UsageTracker) HaveAccess(conf *config.Config) bool {
-	for _, id := range conf.AdminChatIDs {
-		idStr := fmt.Sprintf("%d", id)
-		if ut.UserID == idStr {
-			log.Println("Admin")
-			return true
-		}
-	}
-
-	for _, id := range conf.AllowedUserChatIDs {
-		idStr := fmt.Sprintf("%d", id)
-		if ut.UserID == idStr {
-			currentCost := ut.GetCurrentCost(conf.BudgetPeriod)
-
-			if float64(conf.UserBudget) > currentCost {
-				log.Println("ID:", idStr, " UserBudget:", conf.UserBudget, " CurrentCost:", currentCost)
-				return true
-			}
-			return false
-		}
+	budget, limited := ut.Budget(conf)
+	if !limited {
+		return true
 	}
-	currentCost := ut.GetCurrentCost(conf.BudgetPeriod)
 
-	if float64(conf.GuestBudget) > currentCost {
-		log.Println("ID:", ut.UserID, " GuestBudget:", conf.GuestBudget, " CurrentCost:", currentCost)
+	currentCost := ut.GetCurrentCost(conf, ut.BudgetPeriod(conf))
+	if budget > currentCost {
+		log.Println("ID:", ut.UserID, " Budget:", budget, " CurrentCost:", currentCost)
 		return true
 	}
 	log.Printf("UserID: %s, AdminChatIDs: %v, AllowedUserChatIDs: %v", ut.UserID, conf.AdminChatIDs, conf.AllowedUserChatIDs)
-	log.Printf("UserBudget: %f, GuestBudget: %f, CurrentCost: %f", float64(conf.UserBudget), float64(conf.GuestBudget), currentCost)
+	log.Printf("Budget: %f, CurrentCost: %f", budget, currentCost)
 	return false
-
 }
 
 func (ut *UsageTracker) GetUserRole(conf *config.Config) string {
@@ -186,7 +173,7 @@ func (ut *UsageTracker) loadUsage() error {
 func (ut *UsageTracker) AddCost(cost float64) {
 	ut.UsageMu.Lock()
 
-	today := time.Now().Format("2006-01-02")
+	today := ut.today()
 	if ut.Usage.UsageHistory.ChatCost == nil { // Добавлена проверка на nil
 		ut.Usage.UsageHistory.ChatCost = make(map[string]float64)
 	}
@@ -199,94 +186,72 @@ func (ut *UsageTracker) AddCost(cost float64) {
 	}
 }
 
-// GetCurrentCost returns the current cost based on the specified period.
-func (ut *UsageTracker) GetCurrentCost(period string) float64 {
+// today returns the current usage day (YYYY-MM-DD) in the configured timezone.
+func (ut *UsageTracker) today() string {
+	location := ut.location
+	if location == nil {
+		location = time.Local
+	}
+	return time.Now().In(location).Format("2006-01-02")
+}
+
+// GetCurrentCost returns the cost of the budget period containing the current time.
+func (ut *UsageTracker) GetCurrentCost(conf *config.Config, period string) float64 {
 	ut.UsageMu.Lock()
 	defer ut.UsageMu.Unlock()
 
-	today := time.Now().Format("2006-01-02")
-	var cost float64
-	var err error
-
-	switch period {
-	case "daily":
-		cost = calculateCostForDay(ut.Usage.UsageHistory.ChatCost, today)
-	case "monthly":
-		cost, err = calculateCostForMonth(ut.Usage.UsageHistory.ChatCost, today)
-		if err != nil {
-			log.Printf("Error calculating monthly cost for user %s: %v", ut.UserID, err)
-			return 0.0 // Или другое значение по умолчанию
+	now := time.Now()
+	cost := 0.0
+	for day, dayCost := range ut.Usage.UsageHistory.ChatCost {
+		if conf.InPeriod(day, period, now) {
+			cost += dayCost
 		}
-	case "total":
-		cost = calculateTotalCost(ut.Usage.UsageHistory.ChatCost)
-	default:
-		log.Printf("Invalid period: %s. Valid periods are 'daily', 'monthly', 'total'.", period)
-		return 0.0
 	}
-
 	return cost
 }
 
-// calculateCostForDay calculates the cost for a specific day from usage history
-func calculateCostForDay(chatCost map[string]float64, day string) float64 {
-	if cost, ok := chatCost[day]; ok {
-		return cost
-	}
-	return 0.0
-}
-
-// calculateCostForMonth calculates the cost for the current month from usage history
-func calculateCostForMonth(chatCost map[string]float64, today string) (float64, error) {
-	cost := 0.0
-	month := today[:7] // Получаем год и месяц в формате "YYYY-MM"
-
-	for date, dailyCost := range chatCost {
-		if strings.HasPrefix(date, month) {
-			cost += dailyCost
-		}
+// Charge adds the cost and the tokens of a generation to the user's usage and
+// to the message it produced.
+func (ut *UsageTracker) Charge(generationID string, cost float64, tokens int) {
+	ut.UsageMu.Lock()
+	today := ut.today()
+	if ut.Usage.UsageHistory.ChatTokens == nil {
+		ut.Usage.UsageHistory.ChatTokens = make(map[string]int)
 	}
+	ut.Usage.UsageHistory.ChatTokens[today] += tokens
+	ut.UsageMu.Unlock()
 
-	return cost, nil
+	ut.AddCost(cost)
+	ut.SetMessageUsage(generationID, cost, tokens)
 }
 
-// calculateTotalCost calculates the total cost from usage history
-func calculateTotalCost(chatCost map[string]float64) float64 {
-	totalCost := 0.0
-	for _, cost := range chatCost {
-		totalCost += cost
+// CorrectCharge adds delta to the cost charged on day (YYYY-MM-DD) for a
+// generation, once its real cost replaces the estimate.
+func (ut *UsageTracker) CorrectCharge(generationID, day string, delta float64) {
+	ut.UsageMu.Lock()
+	if ut.Usage.UsageHistory.ChatCost == nil {
+		ut.Usage.UsageHistory.ChatCost = make(map[string]float64)
 	}
-	return totalCost
-}
-
-// GetUsageFromApi Get cost of current generation
-func (ut *UsageTracker) GetUsageFromApi(id string, conf *config.Config) error {
-	url := fmt.Sprintf("https://openrouter.ai/api/v1/generation?id=%s", id)
+	ut.Usage.UsageHistory.ChatCost[day] += delta
+	ut.UsageMu.Unlock()
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.Printf("Error creating request for user %s: %v", ut.UserID, err)
-		return fmt.Errorf("error creating request: %w", err)
+	if err := ut.saveUsage(); err != nil {
+		log.Printf("Failed to save usage after correcting cost for user %s: %v", ut.UserID, err)
 	}
+	ut.SetMessageUsage(generationID, delta, 0)
+}
 
-	bearer := fmt.Sprintf("Bearer %s", conf.OpenAIApiKey)
-	req.Header.Add("Authorization", bearer)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending request for user %s: %v", ut.UserID, err)
-		return fmt.Errorf("error sending request: %w", err)
-	}
-	defer resp.Body.Close()
+// GetCurrentTokens returns the tokens used in the budget period containing the current time.
+func (ut *UsageTracker) GetCurrentTokens(conf *config.Config, period string) int {
+	ut.UsageMu.Lock()
+	defer ut.UsageMu.Unlock()
 
-	var generationResponse GenerationResponse
-	err = json.NewDecoder(resp.Body).Decode(&generationResponse)
-	if err != nil {
-		log.Printf("Error decoding response for user %s: %v", ut.UserID, err)
-		return fmt.Errorf("error decoding response: %w", err)
+	now := time.Now()
+	tokens := 0
+	for day, dayTokens := range ut.Usage.UsageHistory.ChatTokens {
+		if conf.InPeriod(day, period, now) {
+			tokens += dayTokens
+		}
 	}
-
-	fmt.Printf("Total Cost for user %s: %.6f\n", ut.UserID, generationResponse.Data.TotalCost)
-	ut.AddCost(generationResponse.Data.TotalCost)
-	return nil
+	return tokens
 }
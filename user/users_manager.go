@@ -2,8 +2,12 @@
 package user
 
 import (
+	"encoding/json"
 	"openrouter-bot/config"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -32,3 +36,94 @@ func (um *Manager) GetUser(userID int64, userName string, conf *config.Config) *
 	um.users[userID] = user
 	return user
 }
+
+// Find returns the user with the ID or the @username. Users who are not
+// loaded are looked up by the name saved in their usage file. An ID is only
+// found if the user has a usage file, so that a typo does not create a user.
+func (um *Manager) Find(query string, conf *config.Config) (*UsageTracker, bool) {
+	if userID, err := strconv.ParseInt(query, 10, 64); err == nil {
+		um.mu.Lock()
+		user, loaded := um.users[userID]
+		um.mu.Unlock()
+		if loaded {
+			return user, true
+		}
+		if _, err := os.Stat(filepath.Join(um.LogsDir, query+".json")); err != nil {
+			return nil, false
+		}
+		return um.GetUser(userID, "", conf), true
+	}
+	name := strings.TrimPrefix(query, "@")
+	if name == "" {
+		return nil, false
+	}
+
+	um.mu.Lock()
+	for _, user := range um.users {
+		if strings.EqualFold(user.UserName, name) {
+			um.mu.Unlock()
+			return user, true
+		}
+	}
+	um.mu.Unlock()
+
+	var found *UsageTracker
+	um.eachUsage(func(userID int64, usage UserUsage) bool {
+		if strings.EqualFold(usage.UserName, name) {
+			found = um.GetUser(userID, usage.UserName, conf)
+			return false
+		}
+		return true
+	})
+	return found, found != nil
+}
+
+// TotalCost returns the spending of all users in the period. Loaded users are
+// counted from memory, as their usage files may be being written.
+func (um *Manager) TotalCost(conf *config.Config, period string) float64 {
+	um.mu.Lock()
+	loaded := make(map[int64]*UsageTracker, len(um.users))
+	for userID, user := range um.users {
+		loaded[userID] = user
+	}
+	um.mu.Unlock()
+
+	total := 0.0
+	for _, user := range loaded {
+		total += user.GetCurrentCost(conf, period)
+	}
+	um.eachUsage(func(userID int64, usage UserUsage) bool {
+		if _, ok := loaded[userID]; !ok {
+			ut := &UsageTracker{UserID: strconv.FormatInt(userID, 10), Usage: &usage}
+			total += ut.GetCurrentCost(conf, period)
+		}
+		return true
+	})
+	return total
+}
+
+// eachUsage calls fn with the usage file of every user until fn returns false.
+func (um *Manager) eachUsage(fn func(userID int64, usage UserUsage) bool) {
+	entries, err := os.ReadDir(um.LogsDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		// Only usage files are named after a user ID
+		userID, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".json"), 10, 64)
+		if err != nil || entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(um.LogsDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var usage UserUsage
+		if json.Unmarshal(data, &usage) != nil {
+			continue
+		}
+		if !fn(userID, usage) {
+			return
+		}
+	}
+}